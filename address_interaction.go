@@ -3,12 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"io/ioutil"
 	"log"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 const (
@@ -18,14 +18,29 @@ const (
 	outputFile = "/mnt/data/addresses.json"
 )
 
+// blockByNumber fetches a block with its transactions, retrying transient failures.
+func blockByNumber(client *RPCPool, number *big.Int) (*types.Block, error) {
+	var block *types.Block
+	err := retryRPC(context.Background(), "eth_getBlockByNumber", func(ctx context.Context) error {
+		var err error
+		block, err = client.BlockByNumber(ctx, number)
+		return err
+	})
+	return block, err
+}
+
 func main() {
-	client, err := ethclient.Dial(infuraURL)
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	flag.Parse()
+
+	client, err := dialRPCPool(rpcURLs(*rpcList))
 	if err != nil {
 		log.Fatalf("Failed to connect to Infura: %v", err)
 	}
+	defer client.Close()
 
 	// Retrieve the latest block number
-	latestBlock, err := client.BlockByNumber(context.Background(), nil)
+	latestBlock, err := blockByNumber(client, nil)
 	if err != nil {
 		log.Fatalf("Failed to get latest block: %v", err)
 	}
@@ -43,7 +58,12 @@ func main() {
 
 		// Instead of filtering logs, get the block and iterate over its transactions
 		for blockNum := toBlock; blockNum <= fromBlock; blockNum++ {
-			block, err := client.BlockByNumber(context.Background(), big.NewInt(int64(blockNum)))
+			block, err := blockByNumber(client, big.NewInt(int64(blockNum)))
+			if isRPCErrorKind(err, rpcMalformed) {
+				// A block this client cannot decode is skipped rather than ending the run
+				log.Printf("Skipping block %d: %v", blockNum, err)
+				continue
+			}
 			if err != nil {
 				log.Fatalf("Failed to retrieve block %d: %v", blockNum, err)
 			}
@@ -51,7 +71,8 @@ func main() {
 			for _, tx := range block.Transactions() {
 				from, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
 				if err != nil {
-					log.Fatalf("Failed to get the sender address from transaction: %v", err)
+					log.Printf("Skipping transaction %s: failed to get the sender address: %v", tx.Hash().Hex(), err)
+					continue
 				} // sender address
 				to := tx.To() // receiver address
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// unlimitedAllowance is the smallest allowance treated as unlimited. Wallets approve the maximum
+// uint256, and tokens that count such approvals down leave them just below it.
+var unlimitedAllowance = new(big.Int).Lsh(big.NewInt(1), 255)
+
+// Allowance is one live allowance a wallet has given, with what is known about its spender.
+type Allowance struct {
+	Token         string  `json:"token"`
+	TokenName     *string `json:"token_name"`
+	TokenSymbol   *string `json:"token_symbol"`
+	TokenDecimals *int64  `json:"token_decimals"`
+
+	Spender           string     `json:"spender"`
+	SpenderLabel      *string    `json:"spender_label"`
+	SpenderIsContract *bool      `json:"spender_is_contract"` // NULL until the spender has been checked
+	SpenderDeployedAt *time.Time `json:"spender_deployed_at"`
+	SpenderAgeDays    *int64     `json:"spender_age_days"`
+
+	Amount    string `json:"amount"`    // In the token's smallest unit
+	Unlimited bool   `json:"unlimited"` // At least 2^255, or at least the token's total supply
+	Checked   bool   `json:"checked"`   // Amount was confirmed with allowance() rather than taken from the last Approval
+
+	ApprovedAt    *time.Time `json:"approved_at"`
+	ApprovalBlock uint64     `json:"approval_block"`
+	ApprovalTx    string     `json:"approval_tx"`
+
+	totalSupply sql.NullString
+}
+
+// normalizeAddressLabels rewrites hand-entered address_labels in the checksummed form every other
+// table stores, so labels can be joined on the address as it is.
+func normalizeAddressLabels() error {
+	rows, err := db.Query(`SELECT chain_id, address FROM address_labels`)
+	if err != nil {
+		return err
+	}
+	type label struct {
+		chain   int64
+		address string
+	}
+	var labels []label
+	for rows.Next() {
+		var l label
+		if err := rows.Scan(&l.chain, &l.address); err != nil {
+			rows.Close()
+			return err
+		}
+		labels = append(labels, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range labels {
+		if !common.IsHexAddress(l.address) {
+			log.Printf("Label address %q on chain %d is not an address", l.address, l.chain)
+			continue
+		}
+		normalized := common.HexToAddress(l.address).Hex()
+		if normalized == l.address {
+			continue
+		}
+		_, err := db.Exec(`UPDATE address_labels SET address = $3 WHERE chain_id = $1 AND address = $2`, l.chain, l.address, normalized)
+		if err != nil {
+			return fmt.Errorf("label of %s on chain %d: %v", l.address, l.chain, err)
+		}
+	}
+	return nil
+}
+
+// walletAllowances returns the allowances a wallet has given whose latest Approval is non-zero,
+// newest first. Spenders are labelled from address_labels, or else as a router, factory or pool
+// in the registry.
+func walletAllowances(chain int64, wallet common.Address) ([]Allowance, error) {
+	query := `
+        SELECT a.token_address, t.name, t.symbol, t.decimals,
+               a.spender_address,
+               COALESCE(
+                   l.label,
+                   (SELECT f.entity_id || ' router' FROM factories f
+                    WHERE f.chain_id = a.chain_id AND f.router = a.spender_address LIMIT 1),
+                   (SELECT f.entity_id || ' factory' FROM factories f
+                    WHERE f.chain_id = a.chain_id AND f.address = a.spender_address LIMIT 1),
+                   (SELECT 'pool ' || COALESCE(t0.symbol, '?') || '/' || COALESCE(t1.symbol, '?') FROM pairs p
+                    LEFT JOIN tokens t0 ON t0.chain_id = p.chain_id AND t0.address = p.token0_address
+                    LEFT JOIN tokens t1 ON t1.chain_id = p.chain_id AND t1.address = p.token1_address
+                    WHERE p.chain_id = a.chain_id AND p.pair_address = a.spender_address)
+               ) AS label,
+               s.is_contract, s.deployed_at,
+               a.value, t.total_supply, a.block_timestamp, a.block_number, a.tx_hash
+        FROM current_allowances a
+        LEFT JOIN tokens t ON t.chain_id = a.chain_id AND t.address = a.token_address
+        LEFT JOIN spenders s ON s.chain_id = a.chain_id AND s.address = a.spender_address
+        LEFT JOIN address_labels l ON l.chain_id = a.chain_id AND l.address = a.spender_address
+        WHERE a.chain_id = $1 AND a.owner_address = $2 AND a.value > 0
+        ORDER BY a.block_number DESC
+    `
+	rows, err := db.Query(query, chain, wallet.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allowances := []Allowance{}
+	for rows.Next() {
+		var a Allowance
+		var name, symbol, label sql.NullString
+		var decimals sql.NullInt64
+		var isContract sql.NullBool
+		var deployedAt, approvedAt sql.NullTime
+		err := rows.Scan(&a.Token, &name, &symbol, &decimals, &a.Spender, &label, &isContract, &deployedAt,
+			&a.Amount, &a.totalSupply, &approvedAt, &a.ApprovalBlock, &a.ApprovalTx)
+		if err != nil {
+			return nil, err
+		}
+
+		a.TokenName, a.TokenSymbol, a.SpenderLabel = nullString(name), nullString(symbol), nullString(label)
+		if decimals.Valid {
+			a.TokenDecimals = &decimals.Int64
+		}
+		if isContract.Valid {
+			a.SpenderIsContract = &isContract.Bool
+		}
+		if deployedAt.Valid {
+			days := int64(time.Since(deployedAt.Time) / (24 * time.Hour))
+			a.SpenderDeployedAt, a.SpenderAgeDays = &deployedAt.Time, &days
+		}
+		if approvedAt.Valid {
+			a.ApprovedAt = &approvedAt.Time
+		}
+		if a.Unlimited, err = isUnlimited(a.Amount, a.totalSupply); err != nil {
+			return nil, err
+		}
+		allowances = append(allowances, a)
+	}
+	return allowances, rows.Err()
+}
+
+// checkAllowances replaces the indexed amounts with what allowance() returns now. Many tokens spend
+// allowances without emitting Approval, so the last Approval can overstate what is left. Allowances
+// that turn out to be spent are dropped; ones the token cannot report are kept unchecked. The calls
+// are sent in batches, and calls a batch loses to the node are retried one by one.
+func checkAllowances(ctx context.Context, client rpcBatchCaller, wallet common.Address, allowances []Allowance) ([]Allowance, error) {
+	results := make([]string, len(allowances))
+	calls := make([]rpc.BatchElem, len(allowances))
+	for i, a := range allowances {
+		args := map[string]interface{}{
+			"to":   common.HexToAddress(a.Token).Hex(),
+			"data": allowanceData(wallet, common.HexToAddress(a.Spender)),
+		}
+		calls[i] = rpc.BatchElem{Method: "eth_call", Args: []interface{}{args, "latest"}, Result: &results[i]}
+	}
+	if err := batchCall(ctx, client, "eth_call", calls); err != nil {
+		return nil, err
+	}
+
+	live := allowances[:0]
+	for i, a := range allowances {
+		token := common.HexToAddress(a.Token)
+		var amount *big.Int
+		var err error
+		switch {
+		case calls[i].Error == nil:
+			amount, err = parseAllowance(token, results[i])
+		case classifyRPCError(calls[i].Error) == rpcReverted:
+			err = &RPCError{Kind: rpcReverted, Method: "eth_call", Err: calls[i].Error}
+		default:
+			if err = limiter.Wait(ctx); err == nil {
+				amount, err = getAllowance(client, token, wallet, common.HexToAddress(a.Spender))
+			}
+		}
+		if isRPCErrorKind(err, rpcReverted) || isRPCErrorKind(err, rpcMalformed) {
+			log.Printf("No allowance() for token %s: %v", a.Token, err)
+			live = append(live, a)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if amount.Sign() == 0 {
+			continue
+		}
+
+		a.Amount, a.Checked = amount.String(), true
+		if a.Unlimited, err = isUnlimited(a.Amount, a.totalSupply); err != nil {
+			return nil, err
+		}
+		live = append(live, a)
+	}
+	return live, nil
+}
+
+// isUnlimited reports whether an allowance lets the spender take every token the owner will ever have.
+func isUnlimited(amount string, totalSupply sql.NullString) (bool, error) {
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		return false, fmt.Errorf("invalid allowance %q", amount)
+	}
+	if value.Cmp(unlimitedAllowance) >= 0 {
+		return true, nil
+	}
+	if !totalSupply.Valid {
+		return false, nil
+	}
+	supply, ok := new(big.Int).SetString(totalSupply.String, 10)
+	return ok && supply.Sign() > 0 && value.Cmp(supply) >= 0, nil
+}
+
+// nullString returns a pointer to the string, or nil when it is NULL.
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
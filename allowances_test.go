@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeAllowanceNode answers allowance() with the result registered for each token; tokens without
+// one revert. Tokens in lost fail inside a batch but answer when asked on their own.
+type fakeAllowanceNode struct {
+	results map[common.Address]string
+	lost    map[common.Address]bool
+
+	batches int
+	single  []common.Address
+}
+
+func (n *fakeAllowanceNode) call(result interface{}, args []interface{}) error {
+	token := common.HexToAddress(args[0].(map[string]interface{})["to"].(string))
+	res, ok := n.results[token]
+	if !ok {
+		return codeError{3, "execution reverted"}
+	}
+	*result.(*string) = res
+	return nil
+}
+
+func (n *fakeAllowanceNode) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	n.single = append(n.single, common.HexToAddress(args[0].(map[string]interface{})["to"].(string)))
+	return n.call(result, args)
+}
+
+func (n *fakeAllowanceNode) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	n.batches++
+	for i := range b {
+		if n.lost[common.HexToAddress(b[i].Args[0].(map[string]interface{})["to"].(string))] {
+			b[i].Error = codeError{-32603, "request timed out"}
+			continue
+		}
+		b[i].Error = n.call(b[i].Result, b[i].Args)
+	}
+	return nil
+}
+
+func TestCheckAllowances(t *testing.T) {
+	wallet := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	spender := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	token := func(n int) common.Address { return common.HexToAddress(fmt.Sprintf("0x%040x", 0x1000+n)) }
+
+	node := &fakeAllowanceNode{
+		results: map[common.Address]string{
+			token(0): "0x" + abiWord(5),
+			token(1): "0x" + abiWord(0),
+			token(3): "0x" + strings.Repeat("f", 64),
+			token(4): "0x01",
+		},
+		lost: map[common.Address]bool{token(3): true},
+	}
+	var allowances []Allowance
+	for i := 0; i < 5; i++ {
+		allowances = append(allowances, Allowance{Token: token(i).Hex(), Spender: spender.Hex(), Amount: "100"})
+	}
+
+	live, err := checkAllowances(context.Background(), node, wallet, allowances)
+	if err != nil {
+		t.Fatalf("checkAllowances failed: %v", err)
+	}
+	if node.batches != 1 || len(node.single) != 1 || node.single[0] != token(3) {
+		t.Errorf("checkAllowances sent %d batches and single calls to %v, want 1 batch and a retry of %s",
+			node.batches, node.single, token(3).Hex())
+	}
+
+	// The spent allowance is dropped; the reverted and malformed ones are kept as indexed
+	var got []string
+	for _, a := range live {
+		got = append(got, fmt.Sprintf("%s:%s:%t:%t", a.Token, a.Amount, a.Checked, a.Unlimited))
+	}
+	want := []string{
+		token(0).Hex() + ":5:true:false",
+		token(2).Hex() + ":100:false:false",
+		token(3).Hex() + ":" + "115792089237316195423570985008687907853269984665640564039457584007913129639935:true:true",
+		token(4).Hex() + ":100:false:false",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("checkAllowances = %v, want %v", got, want)
+	}
+}
+
+func TestNormalizeAddressLabels(t *testing.T) {
+	weth := common.HexToAddress("0x4200000000000000000000000000000000000006")
+	router := common.HexToAddress("0xcf77a3ba9a5ca399b7c97c74d54e5b1beb874e43")
+
+	fake := useFakeDB(t)
+	fake.query = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		return []string{"chain_id", "address"}, [][]driver.Value{
+			{int64(8453), strings.ToLower(router.Hex())},
+			{int64(8453), weth.Hex()},
+			{int64(8453), "uniswap router"},
+		}, nil
+	}
+	if err := normalizeAddressLabels(); err != nil {
+		t.Fatalf("normalizeAddressLabels failed: %v", err)
+	}
+
+	var updates []string
+	for _, statement := range fake.executed() {
+		if strings.HasPrefix(statement.query, "UPDATE address_labels") {
+			updates = append(updates, fmt.Sprint(statement.args))
+		}
+	}
+	want := fmt.Sprint([]driver.Value{int64(8453), strings.ToLower(router.Hex()), router.Hex()})
+	if len(updates) != 1 || updates[0] != want {
+		t.Errorf("normalizeAddressLabels updated %v, want only %s", updates, want)
+	}
+}
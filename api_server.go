@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/time/rate"
+)
+
+var limiter = rate.NewLimiter(rate.Limit(24), 1) // 24 requests per second
+
+const (
+	infuraURL = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
+
+	defaultCandleLimit = 200  // Bars returned by /api/candles without a limit
+	maxCandleLimit     = 2000 // Largest limit /api/candles accepts
+)
+
+// apiServer answers the frontend's queries from the indexed tables.
+type apiServer struct {
+	client  *RPCPool
+	chainID int64
+}
+
+// writeJSON sends value as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// writeError sends an error message as JSON.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// handleApprovals lists a wallet's allowances: GET /api/approvals?wallet=0x...[&check=false].
+// Unless check=false, each amount is confirmed with allowance() and spent allowances are left out.
+func (s *apiServer) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	wallet := r.URL.Query().Get("wallet")
+	if !common.IsHexAddress(wallet) {
+		writeError(w, http.StatusBadRequest, "wallet must be a 0x-prefixed address")
+		return
+	}
+	owner := common.HexToAddress(wallet)
+
+	allowances, err := walletAllowances(s.chainID, owner)
+	if err != nil {
+		log.Printf("Failed to load allowances of %s: %v", owner.Hex(), err)
+		writeError(w, http.StatusInternalServerError, "failed to load allowances")
+		return
+	}
+	if r.URL.Query().Get("check") != "false" {
+		if allowances, err = checkAllowances(r.Context(), s.client, owner, allowances); err != nil {
+			log.Printf("Failed to check allowances of %s: %v", owner.Hex(), err)
+			writeError(w, http.StatusBadGateway, "failed to check allowances on chain")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"wallet":     owner.Hex(),
+		"allowances": allowances,
+	})
+}
+
+// handleCandles returns a pool's bars: GET /api/candles?pool=0x...&period=5m[&limit=200].
+func (s *apiServer) handleCandles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	pool := r.URL.Query().Get("pool")
+	if !common.IsHexAddress(pool) {
+		writeError(w, http.StatusBadRequest, "pool must be a 0x-prefixed address")
+		return
+	}
+	period := r.URL.Query().Get("period")
+	switch period {
+	case "30s", "1m", "5m", "10m", "30m", "1h", "1d":
+	default:
+		writeError(w, http.StatusBadRequest, "period must be one of 30s, 1m, 5m, 10m, 30m, 1h or 1d")
+		return
+	}
+	limit := defaultCandleLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxCandleLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCandleLimit))
+			return
+		}
+		limit = n
+	}
+
+	candles, err := poolCandles(s.chainID, common.HexToAddress(pool), period, limit)
+	if err != nil {
+		log.Printf("Failed to load %s candles of %s: %v", period, pool, err)
+		writeError(w, http.StatusInternalServerError, "failed to load candles")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pool":    common.HexToAddress(pool).Hex(),
+		"period":  period,
+		"candles": candles,
+	})
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	frontendDir := flag.String("frontend", "frontend", "directory of the frontend served at /")
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	flag.Parse()
+
+	initDB() // Initialize the database; the monitors own the schema
+	if err := normalizeAddressLabels(); err != nil {
+		log.Fatalf("Failed to normalize address labels: %v", err)
+	}
+
+	client, err := dialRPCPool(rpcURLs(*rpcList))
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+	defer client.Close()
+
+	chain, err := client.ChainID(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to get the chain id: %v", err)
+	}
+	server := &apiServer{client: client, chainID: chain.Int64()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/approvals", server.handleApprovals)
+	mux.HandleFunc("/api/candles", server.handleCandles)
+	mux.Handle("/", http.FileServer(http.Dir(*frontendDir)))
+
+	log.Printf("Serving the API and %s on %s", *frontendDir, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
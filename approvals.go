@@ -0,0 +1,49 @@
+package main
+
+import "github.com/ethereum/go-ethereum/core/types"
+
+// The ERC-20 Approval event. ERC-721 approvals share the topic with the token id indexed, so they
+// are quarantined like ERC-721 transfers.
+func init() {
+	approvals := registerEventGroup("approvals", "approvals")
+	approvals.register("event Approval(address indexed owner, address indexed spender, uint256 value)", tokenApproval)
+}
+
+// approvalsTable writes approvals keyed by their log, so rescanning a range rewrites the same rows.
+var approvalsTable = &batchTable{
+	name: "approvals",
+	columns: []string{"chain_id", "token_address", "block_number", "block_hash", "block_timestamp", "tx_hash", "log_index",
+		"owner_address", "spender_address", "value"},
+	conflict: `
+        ON CONFLICT (chain_id, tx_hash, log_index) DO UPDATE SET
+            token_address   = EXCLUDED.token_address,
+            block_number    = EXCLUDED.block_number,
+            block_hash      = EXCLUDED.block_hash,
+            block_timestamp = COALESCE(EXCLUDED.block_timestamp, approvals.block_timestamp),
+            owner_address   = EXCLUDED.owner_address,
+            spender_address = EXCLUDED.spender_address,
+            value           = EXCLUDED.value
+    `,
+	key: []int{0, 5, 6},
+}
+
+// tokenApproval queues an approval and the spender it names.
+func tokenApproval(vLog types.Log, values map[string]interface{}) error {
+	owner, err := addressValue(values, "owner")
+	if err != nil {
+		return err
+	}
+	spender, err := addressValue(values, "spender")
+	if err != nil {
+		return err
+	}
+	value, err := bigValue(values, "value")
+	if err != nil {
+		return err
+	}
+
+	rowWriter.add(approvalsTable, chainID, vLog.Address.Hex(), vLog.BlockNumber, vLog.BlockHash.Hex(), logTimestamp(vLog),
+		vLog.TxHash.Hex(), vLog.Index, owner.Hex(), spender.Hex(), value.String())
+	queueSpender(spender, vLog.BlockNumber)
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	maxStatementParams = 65535 // Postgres limit on bind parameters in one statement
+	maxStatementRows   = 1000  // Rows written by one multi-row INSERT
+)
+
+// batchTable describes how buffered rows are written to one table.
+type batchTable struct {
+	name     string
+	columns  []string
+	conflict string // ON CONFLICT clause appended to every statement, usually an upsert on the table's unique key
+	key      []int  // Columns identifying a row; a later row with the same key replaces the buffered one
+
+	// Keep the first buffered row for a key instead of replacing it. Logs are added in block order,
+	// so the first row has the earliest block.
+	keepFirst bool
+}
+
+// batchWriter buffers decoded rows and writes them with multi-row inserts in a single transaction
+// together with the scanner checkpoints, so a range is either stored completely or not at all.
+// It is not safe for concurrent use; the scanners add and flush from one goroutine.
+type batchWriter struct {
+	tables []*batchTable                   // In the order rows were first added, which is the order they are written
+	rows   map[*batchTable][][]interface{} // Buffered rows per table
+	keys   map[*batchTable]map[string]int  // Position of each buffered row by key
+	queued int                             // Rows buffered since the last flush
+	hooks  []func(tx *sql.Tx) error        // Run in the next flush's transaction
+
+	written int       // Rows written since the first flush
+	started time.Time // When the first row was added
+}
+
+var rowWriter = newBatchWriter() // Shared by every table a scanner writes to
+
+// newBatchWriter returns an empty writer.
+func newBatchWriter() *batchWriter {
+	return &batchWriter{
+		rows: make(map[*batchTable][][]interface{}),
+		keys: make(map[*batchTable]map[string]int),
+	}
+}
+
+// add buffers one row for table. values must follow table.columns.
+func (w *batchWriter) add(table *batchTable, values ...interface{}) {
+	if len(values) != len(table.columns) {
+		log.Fatalf("Row for %s has %d values, expected %d", table.name, len(values), len(table.columns))
+	}
+	if w.started.IsZero() {
+		w.started = time.Now()
+	}
+	if _, ok := w.rows[table]; !ok {
+		w.tables = append(w.tables, table)
+		w.keys[table] = make(map[string]int)
+	}
+
+	// Postgres rejects an upsert that touches the same row twice, so keep only one version
+	if len(table.key) > 0 {
+		parts := make([]string, len(table.key))
+		for i, column := range table.key {
+			parts[i] = fmt.Sprint(values[column])
+		}
+		key := strings.Join(parts, "|")
+		if i, ok := w.keys[table][key]; ok {
+			if !table.keepFirst {
+				w.rows[table][i] = values
+			}
+			return
+		}
+		w.keys[table][key] = len(w.rows[table])
+	}
+	w.rows[table] = append(w.rows[table], values)
+	w.queued++
+}
+
+// inTransaction runs hook in the transaction of the next flush, after the buffered rows are written
+// and before the checkpoints, so that what it writes is committed together with them.
+func (w *batchWriter) inTransaction(hook func(tx *sql.Tx) error) {
+	w.hooks = append(w.hooks, hook)
+}
+
+// flush writes every buffered row and, if scanners are given, checkpoints them at lastBlock in the
+// same transaction. Without scanners lastBlock is ignored.
+func (w *batchWriter) flush(lastBlock uint64, scanners ...string) {
+	if w.queued == 0 && len(w.hooks) == 0 && len(scanners) == 0 {
+		return
+	}
+	started := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatalf("Failed to begin a write transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range w.tables {
+		if err := writeRows(tx, table, w.rows[table]); err != nil {
+			log.Fatalf("Failed to write %d rows to %s: %v", len(w.rows[table]), table.name, err)
+		}
+	}
+	for _, hook := range w.hooks {
+		if err := hook(tx); err != nil {
+			log.Fatalf("Failed to complete the write transaction: %v", err)
+		}
+	}
+	for _, scanner := range scanners {
+		if err := writeCheckpoint(tx, scanner, lastBlock); err != nil {
+			log.Fatalf("Failed to save checkpoint for %s: %v", scanner, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("Failed to commit %d rows: %v", w.queued, err)
+	}
+
+	if w.queued > 0 {
+		w.written += w.queued
+		elapsed := time.Since(started)
+		log.Printf("Wrote %d rows in %s (%.0f rows/s, %.0f rows/s overall)", w.queued, elapsed.Round(time.Millisecond),
+			float64(w.queued)/elapsed.Seconds(), float64(w.written)/time.Since(w.started).Seconds())
+	}
+
+	w.tables = nil
+	w.rows = make(map[*batchTable][][]interface{})
+	w.keys = make(map[*batchTable]map[string]int)
+	w.queued = 0
+	w.hooks = nil
+}
+
+// writeRows inserts rows into table with as few multi-row statements as the parameter limit allows.
+func writeRows(tx *sql.Tx, table *batchTable, rows [][]interface{}) error {
+	perStatement := maxStatementParams / len(table.columns)
+	if perStatement > maxStatementRows {
+		perStatement = maxStatementRows
+	}
+
+	for len(rows) > 0 {
+		n := perStatement
+		if n > len(rows) {
+			n = len(rows)
+		}
+
+		var query strings.Builder
+		fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", table.name, strings.Join(table.columns, ", "))
+		args := make([]interface{}, 0, n*len(table.columns))
+		for i, row := range rows[:n] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for j := range row {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+j+1)
+			}
+			query.WriteString(")")
+			args = append(args, row...)
+		}
+		query.WriteString(" " + table.conflict)
+
+		if _, err := tx.Exec(query.String(), args...); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchWriterFlush(t *testing.T) {
+	fake := useFakeDB(t)
+	pairs := &batchTable{name: "pairs", columns: []string{"address", "block"}, conflict: "ON CONFLICT (address) DO NOTHING", key: []int{0}}
+	swaps := &batchTable{name: "swaps", columns: []string{"pool", "amount"}}
+
+	w := newBatchWriter()
+	w.add(swaps, "0xa", 1)
+	w.add(pairs, "0xa", 10)
+	w.add(pairs, "0xb", 11)
+	w.add(pairs, "0xa", 12) // Replaces the first row for 0xa in place
+	w.add(swaps, "0xa", 1)  // Rows without a key are never merged
+	w.flush(99, "topic_monitor")
+
+	want := []fakeStatement{
+		{query: "BEGIN"},
+		{query: "INSERT INTO swaps (pool, amount) VALUES ($1, $2), ($3, $4) ",
+			args: []driver.Value{"0xa", int64(1), "0xa", int64(1)}},
+		{query: "INSERT INTO pairs (address, block) VALUES ($1, $2), ($3, $4) ON CONFLICT (address) DO NOTHING",
+			args: []driver.Value{"0xa", int64(12), "0xb", int64(11)}},
+		{query: "scan_checkpoints", args: []driver.Value{"topic_monitor", int64(99)}},
+		{query: "COMMIT"},
+	}
+	got := fake.executed()
+	if len(got) != len(want) {
+		t.Fatalf("flush ran %d statements, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !strings.Contains(got[i].query, want[i].query) || !reflect.DeepEqual(got[i].args, want[i].args) {
+			t.Errorf("statement %d = %q %v, want %q %v", i, got[i].query, got[i].args, want[i].query, want[i].args)
+		}
+	}
+
+	if w.queued != 0 || len(w.tables) != 0 {
+		t.Errorf("writer still holds %d rows of %d tables after flushing", w.queued, len(w.tables))
+	}
+}
+
+func TestBatchWriterFlushWithoutWork(t *testing.T) {
+	fake := useFakeDB(t)
+	newBatchWriter().flush(99)
+	if statements := fake.executed(); len(statements) != 0 {
+		t.Errorf("flush with nothing to write ran %v", statements)
+	}
+}
+
+func TestWriteRowsStatementSizes(t *testing.T) {
+	fake := useFakeDB(t)
+	narrow := &batchTable{name: "narrow", columns: []string{"a"}}
+	wide := &batchTable{name: "wide", columns: make([]string, 100)}
+
+	w := newBatchWriter()
+	for i := 0; i < 2500; i++ {
+		w.add(narrow, i)
+	}
+	for i := 0; i < 700; i++ {
+		w.add(wide, make([]interface{}, 100)...)
+	}
+	w.flush(0)
+
+	// maxStatementRows caps the narrow table; the bind parameter limit the wide one
+	var sizes []int
+	for _, statement := range fake.executed() {
+		if strings.HasPrefix(statement.query, "INSERT INTO narrow") {
+			sizes = append(sizes, len(statement.args))
+		}
+		if strings.HasPrefix(statement.query, "INSERT INTO wide") {
+			sizes = append(sizes, len(statement.args)/100)
+		}
+	}
+	if want := []int{1000, 1000, 500, 655, 45}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("rows per statement = %v, want %v", sizes, want)
+	}
+}
+
+func TestBatchWriterKeepFirst(t *testing.T) {
+	tokens := &batchTable{name: "tokens", columns: []string{"address", "first_seen_block"}, key: []int{0}, keepFirst: true}
+
+	w := newBatchWriter()
+	w.add(tokens, "0xa", 10)
+	w.add(tokens, "0xb", 11)
+	w.add(tokens, "0xa", 12)
+	if want := [][]interface{}{{"0xa", 10}, {"0xb", 11}}; !reflect.DeepEqual(w.rows[tokens], want) {
+		t.Errorf("buffered %v, want the first row of each token: %v", w.rows[tokens], want)
+	}
+}
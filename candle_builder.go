@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/time/rate"
+)
+
+var limiter = rate.NewLimiter(rate.Limit(24), 1) // 24 requests per second
+
+const (
+	infuraURL = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
+
+	candleScanner    = "candle_builder" // Checkpoint name for this builder
+	candlePageBlocks = 1800             // Blocks of swaps merged per transaction, one hour on Base
+
+	candlePollInterval = 2 * time.Second // Base produces a block every 2 seconds
+)
+
+// loadCandleSwaps returns the swaps stored for [fromBlock, toBlock] in chain order.
+func loadCandleSwaps(fromBlock, toBlock uint64) []candleSwap {
+	query := `
+        SELECT s.pool_address, p.token0_address, p.token1_address, t0.decimals, t1.decimals,
+               s.block_number, s.block_timestamp, s.amount0_in, s.amount1_in, s.amount0_out, s.amount1_out
+        FROM swaps s
+        JOIN pairs p ON p.chain_id = s.chain_id AND p.pair_address = s.pool_address
+        LEFT JOIN tokens t0 ON t0.chain_id = p.chain_id AND t0.address = p.token0_address
+        LEFT JOIN tokens t1 ON t1.chain_id = p.chain_id AND t1.address = p.token1_address
+        WHERE s.chain_id = $1 AND s.block_number BETWEEN $2 AND $3
+        ORDER BY s.block_number, s.log_index
+    `
+	rows, err := db.Query(query, chainID, fromBlock, toBlock)
+	if err != nil {
+		log.Fatalf("Failed to load swaps: %v", err)
+	}
+	defer rows.Close()
+
+	var swaps []candleSwap
+	for rows.Next() {
+		var swap candleSwap
+		var pool, token0, token1 string
+		var blockTime sql.NullTime
+		err := rows.Scan(&pool, &token0, &token1, &swap.Decimals0, &swap.Decimals1, &swap.Block, &blockTime,
+			&swap.Amount0In, &swap.Amount1In, &swap.Amount0Out, &swap.Amount1Out)
+		if err != nil {
+			log.Fatalf("Failed to load swaps: %v", err)
+		}
+		swap.Pool, swap.Token0, swap.Token1 = common.HexToAddress(pool), common.HexToAddress(token0), common.HexToAddress(token1)
+		swap.Time = blockTime.Time
+		swaps = append(swaps, swap)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to load swaps: %v", err)
+	}
+	return swaps
+}
+
+// buildCandles merges the swaps of [fromBlock, toBlock] into the bars and checkpoints the range in
+// the same transaction, then closes the bars that ended by toBlock.
+func buildCandles(builder *candleBuilder, fromBlock, toBlock uint64) {
+	swaps := loadCandleSwaps(fromBlock, toBlock)
+	log.Printf("Processing blocks %d to %d: found %d swaps", fromBlock, toBlock, len(swaps))
+	for _, swap := range swaps {
+		builder.add(swap)
+	}
+	builder.queue()
+	rowWriter.flush(toBlock, candleScanner)
+
+	blockTime, err := blockTimestamp(toBlock)
+	if err != nil {
+		log.Printf("Failed to get the timestamp of block %d, closing bars later: %v", toBlock, err)
+		return
+	}
+	if _, err := db.Exec(`UPDATE candles SET closed = true WHERE chain_id = $1 AND NOT closed AND bucket_end <= $2`, chainID, blockTime); err != nil {
+		log.Fatalf("Failed to close bars: %v", err)
+	}
+}
+
+// rewindCandles handles rewinds of the swaps scanner since the last call, such as by a reorg
+// rollback, after which the swaps from the rewound block on may change. A merged bar cannot give a
+// swap back, so every bar from the start of the affected day is deleted and rebuilt. It returns the
+// block to continue from, which is nextBlock when the bars are not affected.
+func rewindCandles(swapsScanner string, nextBlock uint64) uint64 {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatalf("Failed to begin a rewind transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var rewound sql.NullInt64
+	query := `
+        WITH claimed AS (DELETE FROM checkpoint_rewinds WHERE scanner = $1 RETURNING block_number)
+        SELECT MIN(block_number) FROM claimed
+    `
+	if err := tx.QueryRow(query, swapsScanner).Scan(&rewound); err != nil {
+		log.Fatalf("Failed to load the rewinds of %s: %v", swapsScanner, err)
+	}
+	if !rewound.Valid || uint64(rewound.Int64) >= nextBlock {
+		// No bar holds swaps from the rewound blocks yet
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("Failed to clear the rewinds of %s: %v", swapsScanner, err)
+		}
+		return nextBlock
+	}
+
+	blockTime, err := blockTimestamp(uint64(rewound.Int64))
+	if err != nil {
+		log.Fatalf("Failed to get the timestamp of block %d: %v", rewound.Int64, err)
+	}
+	dayStart := blockTime.Truncate(24 * time.Hour)
+
+	// The day's bars hold every swap from the first block they name on
+	var firstBlock sql.NullInt64
+	err = tx.QueryRow(`SELECT MIN(first_block) FROM candles WHERE chain_id = $1 AND bucket_start >= $2`, chainID, dayStart).Scan(&firstBlock)
+	if err != nil {
+		log.Fatalf("Failed to find the bars to rebuild: %v", err)
+	}
+	fromBlock := uint64(rewound.Int64)
+	if firstBlock.Valid && uint64(firstBlock.Int64) < fromBlock {
+		fromBlock = uint64(firstBlock.Int64)
+	}
+
+	result, err := tx.Exec(`DELETE FROM candles WHERE chain_id = $1 AND bucket_start >= $2`, chainID, dayStart)
+	if err != nil {
+		log.Fatalf("Failed to delete the bars to rebuild: %v", err)
+	}
+	if fromBlock > 0 {
+		err = writeCheckpoint(tx, candleScanner, fromBlock-1)
+	} else {
+		_, err = tx.Exec(`DELETE FROM scan_checkpoints WHERE scanner = $1`, candleScanner)
+	}
+	if err != nil {
+		log.Fatalf("Failed to rewind %s: %v", candleScanner, err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("Failed to commit the candle rewind: %v", err)
+	}
+
+	removed, _ := result.RowsAffected()
+	log.Printf("%s was rewound to block %d; removed %d bars since %s and rebuilding from block %d",
+		swapsScanner, rewound.Int64, removed, dayStart.Format(time.RFC3339), fromBlock)
+	return fromBlock
+}
+
+// lastETHUSD returns the close of the reference pool's last 30s bar before block, so USD prices
+// continue where the stored bars left off.
+func lastETHUSD(ethUSDPool common.Address, block uint64) float64 {
+	var price float64
+	query := `
+        SELECT close FROM candles
+        WHERE chain_id = $1 AND pool_address = $2 AND period = '30s' AND last_block < $3
+        ORDER BY bucket_start DESC
+        LIMIT 1
+    `
+	err := db.QueryRow(query, chainID, ethUSDPool.Hex(), block).Scan(&price)
+	if err != nil && err != sql.ErrNoRows {
+		log.Fatalf("Failed to load the WETH price: %v", err)
+	}
+	return price
+}
+
+func main() {
+	rewind := flag.Int64("rewind", -1, "delete the bars from this block's day on and rebuild them")
+	follow := flag.Bool("follow", false, "keep building bars as new swaps are indexed")
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	swapsScanner := flag.String("swaps", "pool_monitor:swaps", "swaps group checkpoint, named <monitor>:swaps, that bars are built up to")
+	ethUSDPool := flag.String("eth-usd-pool", "0xd0b53D9277642d899DF5C87A3966A349A798F224", "WETH/stablecoin pool that prices WETH in USD")
+	flag.Parse()
+
+	initDB() // Initialize the database
+
+	// "migrate up|down|status" only manages the schema; otherwise bring it up to date and build
+	if flag.Arg(0) == "migrate" {
+		runMigrateCommand(flag.Args()[1:])
+		return
+	}
+	migrateUp()
+
+	log.Println("Starting script...")
+
+	client, err := dialRPCPool(rpcURLs(*rpcList))
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+	defer client.Close()
+
+	initPairs(client)
+
+	if *rewind >= 0 {
+		// Handled like a rewind of the swaps, so the whole day is rebuilt
+		if _, err := db.Exec(`INSERT INTO checkpoint_rewinds (scanner, block_number) VALUES ($1, $2)`, *swapsScanner, *rewind); err != nil {
+			log.Fatalf("Failed to rewind %s: %v", candleScanner, err)
+		}
+	}
+
+	// Bars can only be built from swaps that have been indexed
+	swapsIndexed := func() uint64 {
+		lastBlock, ok := loadCheckpoint(*swapsScanner)
+		if !ok {
+			log.Fatalf("No checkpoint for %s; index the swaps first", *swapsScanner)
+		}
+		return lastBlock
+	}
+
+	var startBlock uint64
+	if err := db.QueryRow(`SELECT COALESCE(MIN(block_number), 0) FROM swaps WHERE chain_id = $1`, chainID).Scan(&startBlock); err != nil {
+		log.Fatalf("Failed to find the first swap: %v", err)
+	}
+	nextBlock := rewindCandles(*swapsScanner, resumeBlock(candleScanner, startBlock))
+
+	builder := newCandleBuilder(common.HexToAddress(*ethUSDPool))
+	builder.ethUSD = lastETHUSD(builder.ethUSDPool, nextBlock)
+	for {
+		endBlock := swapsIndexed()
+		for nextBlock <= endBlock {
+			toBlock := nextBlock + candlePageBlocks - 1
+			if toBlock > endBlock {
+				toBlock = endBlock
+			}
+			buildCandles(builder, nextBlock, toBlock)
+			nextBlock = toBlock + 1
+		}
+		if !*follow {
+			break
+		}
+
+		// The open bars are updated as soon as pool_monitor stores new swaps
+		time.Sleep(candlePollInterval)
+		if rewound := rewindCandles(*swapsScanner, nextBlock); rewound != nextBlock {
+			nextBlock = rewound
+			builder.ethUSD = lastETHUSD(builder.ethUSDPool, nextBlock)
+		}
+	}
+
+	log.Println("Script completed.")
+}
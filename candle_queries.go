@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Candle is one stored OHLCV bar of a pool. Prices are in the quote token per base token and in USD.
+type Candle struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	BaseToken   string    `json:"base_token"`
+	QuoteToken  string    `json:"quote_token"`
+	Open        float64   `json:"open"`
+	High        float64   `json:"high"`
+	Low         float64   `json:"low"`
+	Close       float64   `json:"close"`
+	OpenUSD     *float64  `json:"open_usd"` // NULL while the quote token has no USD price
+	HighUSD     *float64  `json:"high_usd"`
+	LowUSD      *float64  `json:"low_usd"`
+	CloseUSD    *float64  `json:"close_usd"`
+	VolumeBase  float64   `json:"volume_base"`
+	VolumeQuote float64   `json:"volume_quote"`
+	VolumeUSD   *float64  `json:"volume_usd"`
+	Trades      int       `json:"trades"`
+	Closed      bool      `json:"closed"` // False for the open bar, which changes until its period ends
+}
+
+// poolCandles returns the latest bars of a pool for a period, oldest first.
+func poolCandles(chain int64, pool common.Address, period string, limit int) ([]Candle, error) {
+	query := `
+        SELECT * FROM (
+            SELECT bucket_start, bucket_end, base_token, quote_token, open, high, low, close,
+                   open_usd, high_usd, low_usd, close_usd, volume_base, volume_quote, volume_usd, trades, closed
+            FROM candles
+            WHERE chain_id = $1 AND pool_address = $2 AND period = $3
+            ORDER BY bucket_start DESC
+            LIMIT $4
+        ) latest
+        ORDER BY bucket_start
+    `
+	rows, err := db.Query(query, chain, pool.Hex(), period, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candles := []Candle{}
+	for rows.Next() {
+		var c Candle
+		var openUSD, highUSD, lowUSD, closeUSD, volumeUSD sql.NullFloat64
+		err := rows.Scan(&c.Start, &c.End, &c.BaseToken, &c.QuoteToken, &c.Open, &c.High, &c.Low, &c.Close,
+			&openUSD, &highUSD, &lowUSD, &closeUSD, &c.VolumeBase, &c.VolumeQuote, &volumeUSD, &c.Trades, &c.Closed)
+		if err != nil {
+			return nil, err
+		}
+		c.OpenUSD, c.HighUSD, c.LowUSD = nullFloat(openUSD), nullFloat(highUSD), nullFloat(lowUSD)
+		c.CloseUSD, c.VolumeUSD = nullFloat(closeUSD), nullFloat(volumeUSD)
+		candles = append(candles, c)
+	}
+	return candles, rows.Err()
+}
+
+// nullFloat returns a pointer to the number, or nil when it is NULL.
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// candlePeriod is the length of one kind of bar.
+type candlePeriod struct {
+	Name   string
+	Length time.Duration
+}
+
+// candlePeriods are the bars built for every pool. Each divides a day, so no bar spans midnight UTC.
+var candlePeriods = []candlePeriod{
+	{"30s", 30 * time.Second},
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"10m", 10 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"1d", 24 * time.Hour},
+}
+
+var (
+	wethToken = common.HexToAddress("0x4200000000000000000000000000000000000006")
+
+	// Stablecoins on Base, priced at one dollar: USDC, USDbC and DAI
+	usdStablecoins = map[common.Address]bool{
+		common.HexToAddress("0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913"): true,
+		common.HexToAddress("0xd9aAEc86B65D86f6A7B5B1b0c42FFA531710b6CA"): true,
+		common.HexToAddress("0x50c5725949A6F0c72E6C4a641F24049A917DB0Cb"): true,
+	}
+)
+
+// quoteRank orders the tokens a pool can be priced in: stablecoins first, then WETH, then anything.
+func quoteRank(token common.Address) int {
+	switch {
+	case usdStablecoins[token]:
+		return 2
+	case token == wethToken:
+		return 1
+	}
+	return 0
+}
+
+// candleSwap is a swap as read back from the swaps table, with its pool's tokens.
+type candleSwap struct {
+	Pool                   common.Address
+	Token0, Token1         common.Address
+	Decimals0, Decimals1   sql.NullInt64
+	Block                  uint64
+	Time                   time.Time
+	Amount0In, Amount1In   string
+	Amount0Out, Amount1Out string
+}
+
+// candleBar is one bar being built. Bars hold only the swaps of the current page; the upsert in
+// candlesTable merges them into the stored bar.
+type candleBar struct {
+	Pool        common.Address
+	Period      string
+	Start, End  time.Time
+	Base, Quote common.Address
+	Open, Close float64
+	High, Low   float64
+	OpenUSD     sql.NullFloat64
+	CloseUSD    sql.NullFloat64
+	HighUSD     sql.NullFloat64
+	LowUSD      sql.NullFloat64
+	VolumeBase  float64
+	VolumeQuote float64
+	VolumeUSD   sql.NullFloat64
+	Trades      int
+	FirstBlock  uint64
+	LastBlock   uint64
+}
+
+// candleKey identifies a bar.
+type candleKey struct {
+	Pool   common.Address
+	Period string
+	Start  int64
+}
+
+// candleBuilder turns swaps, added in chain order, into bars.
+type candleBuilder struct {
+	ethUSDPool common.Address // WETH/stablecoin pool whose swaps set the USD price of WETH
+	ethUSD     float64        // Latest USD price of WETH; 0 until the reference pool has traded
+
+	bars    map[candleKey]*candleBar
+	order   []candleKey // Bars in the order they were opened
+	skipped int         // Swaps since the last flush that could not be priced
+}
+
+// newCandleBuilder returns a builder that prices WETH from the given pool.
+func newCandleBuilder(ethUSDPool common.Address) *candleBuilder {
+	return &candleBuilder{ethUSDPool: ethUSDPool, bars: make(map[candleKey]*candleBar)}
+}
+
+// add prices a swap and adds it to the pool's bar of every period. Swaps without token decimals,
+// without a block time, or that do not trade one token for the other are skipped.
+func (b *candleBuilder) add(swap candleSwap) {
+	if !swap.Decimals0.Valid || !swap.Decimals1.Valid || swap.Time.IsZero() {
+		b.skipped++
+		return
+	}
+
+	// Price the pool's other token in its best quote token
+	base, quote := swap.Token0, swap.Token1
+	baseIn, baseOut := tokenAmount(swap.Amount0In, swap.Decimals0.Int64), tokenAmount(swap.Amount0Out, swap.Decimals0.Int64)
+	quoteIn, quoteOut := tokenAmount(swap.Amount1In, swap.Decimals1.Int64), tokenAmount(swap.Amount1Out, swap.Decimals1.Int64)
+	if quoteRank(swap.Token0) > quoteRank(swap.Token1) {
+		base, quote = swap.Token1, swap.Token0
+		baseIn, baseOut, quoteIn, quoteOut = quoteIn, quoteOut, baseIn, baseOut
+	}
+
+	var baseAmount, quoteAmount float64
+	switch {
+	case baseIn > 0 && quoteOut > 0:
+		baseAmount, quoteAmount = baseIn, quoteOut
+	case baseOut > 0 && quoteIn > 0:
+		baseAmount, quoteAmount = baseOut, quoteIn
+	default:
+		b.skipped++
+		return
+	}
+	price := quoteAmount / baseAmount
+	if math.IsInf(price, 0) || math.IsNaN(price) || price == 0 {
+		b.skipped++
+		return
+	}
+
+	if swap.Pool == b.ethUSDPool && base == wethToken && usdStablecoins[quote] {
+		b.ethUSD = price
+	}
+	var quoteUSD float64
+	switch {
+	case usdStablecoins[quote]:
+		quoteUSD = 1
+	case quote == wethToken:
+		quoteUSD = b.ethUSD
+	}
+
+	for _, period := range candlePeriods {
+		seconds := int64(period.Length / time.Second)
+		start := swap.Time.Unix() / seconds * seconds
+		key := candleKey{Pool: swap.Pool, Period: period.Name, Start: start}
+
+		bar, ok := b.bars[key]
+		if !ok {
+			bar = &candleBar{
+				Pool: swap.Pool, Period: period.Name,
+				Start: time.Unix(start, 0).UTC(), End: time.Unix(start+seconds, 0).UTC(),
+				Base: base, Quote: quote,
+				Open: price, High: price, Low: price,
+				FirstBlock: swap.Block,
+			}
+			b.bars[key] = bar
+			b.order = append(b.order, key)
+		}
+		bar.High = math.Max(bar.High, price)
+		bar.Low = math.Min(bar.Low, price)
+		bar.Close = price
+		bar.VolumeBase += baseAmount
+		bar.VolumeQuote += quoteAmount
+		bar.Trades++
+		bar.LastBlock = swap.Block
+
+		if quoteUSD > 0 {
+			usd := price * quoteUSD
+			if !bar.OpenUSD.Valid {
+				bar.OpenUSD = sql.NullFloat64{Float64: usd, Valid: true}
+			}
+			if !bar.HighUSD.Valid || usd > bar.HighUSD.Float64 {
+				bar.HighUSD = sql.NullFloat64{Float64: usd, Valid: true}
+			}
+			if !bar.LowUSD.Valid || usd < bar.LowUSD.Float64 {
+				bar.LowUSD = sql.NullFloat64{Float64: usd, Valid: true}
+			}
+			bar.CloseUSD = sql.NullFloat64{Float64: usd, Valid: true}
+			bar.VolumeUSD = sql.NullFloat64{Float64: bar.VolumeUSD.Float64 + quoteAmount*quoteUSD, Valid: true}
+		}
+	}
+}
+
+// queue hands the bars built since the last call to rowWriter and starts over.
+func (b *candleBuilder) queue() {
+	if b.skipped > 0 {
+		log.Printf("Skipped %d swaps that could not be priced", b.skipped)
+	}
+	for _, key := range b.order {
+		bar := b.bars[key]
+		rowWriter.add(candlesTable, chainID, bar.Pool.Hex(), bar.Period, bar.Start, bar.End, bar.Base.Hex(), bar.Quote.Hex(),
+			bar.Open, bar.High, bar.Low, bar.Close, bar.OpenUSD, bar.HighUSD, bar.LowUSD, bar.CloseUSD,
+			bar.VolumeBase, bar.VolumeQuote, bar.VolumeUSD, bar.Trades, bar.FirstBlock, bar.LastBlock)
+	}
+	b.bars = make(map[candleKey]*candleBar)
+	b.order = nil
+	b.skipped = 0
+}
+
+// tokenAmount converts a raw NUMERIC amount to whole tokens.
+func tokenAmount(raw string, decimals int64) float64 {
+	value, ok := new(big.Float).SetString(raw)
+	if !ok {
+		return 0
+	}
+	value.Quo(value, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(decimals), nil)))
+	amount, _ := value.Float64()
+	return amount
+}
+
+// candlesTable merges the bars of a page into the stored bars: the stored open is kept, highs and
+// lows are widened, the close is replaced and volumes are added. The page and the checkpoint are
+// written together, so no swap is merged twice.
+var candlesTable = &batchTable{
+	name: "candles",
+	columns: []string{"chain_id", "pool_address", "period", "bucket_start", "bucket_end", "base_token", "quote_token",
+		"open", "high", "low", "close", "open_usd", "high_usd", "low_usd", "close_usd",
+		"volume_base", "volume_quote", "volume_usd", "trades", "first_block", "last_block"},
+	conflict: `
+        ON CONFLICT (chain_id, pool_address, period, bucket_start) DO UPDATE SET
+            high         = GREATEST(candles.high, EXCLUDED.high),
+            low          = LEAST(candles.low, EXCLUDED.low),
+            close        = EXCLUDED.close,
+            open_usd     = COALESCE(candles.open_usd, EXCLUDED.open_usd),
+            high_usd     = GREATEST(candles.high_usd, EXCLUDED.high_usd),
+            low_usd      = LEAST(candles.low_usd, EXCLUDED.low_usd),
+            close_usd    = COALESCE(EXCLUDED.close_usd, candles.close_usd),
+            volume_base  = candles.volume_base + EXCLUDED.volume_base,
+            volume_quote = candles.volume_quote + EXCLUDED.volume_quote,
+            volume_usd   = COALESCE(candles.volume_usd + EXCLUDED.volume_usd, candles.volume_usd, EXCLUDED.volume_usd),
+            trades       = candles.trades + EXCLUDED.trades,
+            last_block   = EXCLUDED.last_block
+    `,
+	key: []int{0, 1, 2, 3},
+}
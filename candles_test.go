@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	usdcToken    = common.HexToAddress("0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913")
+	memeToken    = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	wethUSDCPool = common.HexToAddress("0x4444444444444444444444444444444444444444")
+	memeWETHPool = common.HexToAddress("0x5555555555555555555555555555555555555555")
+
+	candleDay = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// sellWETH returns a swap of weth WETH for usdc USDC in the WETH/USDC pool at the given second of candleDay.
+func sellWETH(block uint64, second int, weth, usdc string) candleSwap {
+	swap := wethUSDCSwap(block, second)
+	swap.Amount0In, swap.Amount1Out = weth+"000000000000000000", usdc+"000000"
+	return swap
+}
+
+// buyWETH returns a swap of usdc USDC for weth WETH in the WETH/USDC pool at the given second of candleDay.
+func buyWETH(block uint64, second int, usdc, weth string) candleSwap {
+	swap := wethUSDCSwap(block, second)
+	swap.Amount1In, swap.Amount0Out = usdc+"000000", weth+"000000000000000000"
+	return swap
+}
+
+func wethUSDCSwap(block uint64, second int) candleSwap {
+	return candleSwap{
+		Pool: wethUSDCPool, Token0: wethToken, Token1: usdcToken,
+		Decimals0: sql.NullInt64{Int64: 18, Valid: true}, Decimals1: sql.NullInt64{Int64: 6, Valid: true},
+		Block: block, Time: candleDay.Add(time.Duration(second) * time.Second),
+		Amount0In: "0", Amount1In: "0", Amount0Out: "0", Amount1Out: "0",
+	}
+}
+
+// bar returns the builder's bar of pool for period starting at the given second of candleDay.
+func bar(t *testing.T, b *candleBuilder, pool common.Address, period string, second int) *candleBar {
+	t.Helper()
+	found, ok := b.bars[candleKey{Pool: pool, Period: period, Start: candleDay.Unix() + int64(second)}]
+	if !ok {
+		t.Fatalf("no %s bar of %s at second %d", period, pool.Hex(), second)
+	}
+	return found
+}
+
+// near reports whether two prices agree up to float rounding.
+func near(x, y float64) bool {
+	return math.Abs(x-y) <= 1e-9*math.Max(1, math.Abs(y))
+}
+
+func TestCandleBuilderSession(t *testing.T) {
+	b := newCandleBuilder(wethUSDCPool)
+
+	// Three trades in the first 30 seconds make one bar of every period
+	b.add(sellWETH(10, 5, "1", "3000"))
+	b.add(buyWETH(11, 12, "4000", "1"))
+	b.add(sellWETH(12, 20, "2", "7000"))
+	if got := len(b.bars); got != len(candlePeriods) {
+		t.Fatalf("%d bars after three trades in one 30s bucket, want %d", got, len(candlePeriods))
+	}
+	first := bar(t, b, wethUSDCPool, "30s", 0)
+	if first.Open != 3000 || first.High != 4000 || first.Low != 3000 || first.Close != 3500 {
+		t.Errorf("OHLC = %v %v %v %v, want 3000 4000 3000 3500", first.Open, first.High, first.Low, first.Close)
+	}
+	if first.VolumeBase != 4 || first.VolumeQuote != 14000 || first.Trades != 3 || first.FirstBlock != 10 || first.LastBlock != 12 {
+		t.Errorf("volume %v/%v over %d trades in blocks %d to %d, want 4/14000 over 3 in 10 to 12",
+			first.VolumeBase, first.VolumeQuote, first.Trades, first.FirstBlock, first.LastBlock)
+	}
+	if first.Base != wethToken || first.Quote != usdcToken {
+		t.Errorf("bar prices %s in %s, want WETH in USDC", first.Base.Hex(), first.Quote.Hex())
+	}
+	if !first.CloseUSD.Valid || first.CloseUSD.Float64 != 3500 || first.VolumeUSD.Float64 != 14000 {
+		t.Errorf("USD close and volume = %v, %v; want 3500, 14000", first.CloseUSD, first.VolumeUSD)
+	}
+
+	// The next 30 seconds open a new short bar, while the minute and longer bars keep merging
+	b.add(buyWETH(25, 35, "2500", "1"))
+	second := bar(t, b, wethUSDCPool, "30s", 30)
+	if second.Open != 2500 || second.Trades != 1 || !second.End.Equal(candleDay.Add(time.Minute)) {
+		t.Errorf("second 30s bar opens at %v with %d trades and ends %v, want 2500, 1 trade, end at one minute", second.Open, second.Trades, second.End)
+	}
+	minute := bar(t, b, wethUSDCPool, "1m", 0)
+	if minute.Open != 3000 || minute.Low != 2500 || minute.Close != 2500 || minute.Trades != 4 || minute.LastBlock != 25 {
+		t.Errorf("1m bar = open %v low %v close %v, %d trades to block %d; want 3000 2500 2500, 4 trades to block 25",
+			minute.Open, minute.Low, minute.Close, minute.Trades, minute.LastBlock)
+	}
+	if day := bar(t, b, wethUSDCPool, "1d", 0); day.Trades != 4 || !day.End.Equal(candleDay.AddDate(0, 0, 1)) {
+		t.Errorf("1d bar has %d trades and ends %v, want 4 and the next midnight", day.Trades, day.End)
+	}
+}
+
+func TestCandleBuilderPricesThroughWETH(t *testing.T) {
+	b := newCandleBuilder(wethUSDCPool)
+	memeSwap := candleSwap{
+		Pool: memeWETHPool, Token0: wethToken, Token1: memeToken,
+		Decimals0: sql.NullInt64{Int64: 18, Valid: true}, Decimals1: sql.NullInt64{Int64: 0, Valid: true},
+		Block: 9, Time: candleDay.Add(3 * time.Second),
+		Amount0In: "1000000000000000000", Amount1In: "0", Amount0Out: "0", Amount1Out: "1000",
+	}
+
+	// Before the reference pool trades there is no WETH price, so no USD price either
+	b.add(memeSwap)
+	if early := bar(t, b, memeWETHPool, "30s", 0); early.CloseUSD.Valid || early.VolumeUSD.Valid {
+		t.Errorf("USD close and volume before WETH is priced = %v, %v; want none", early.CloseUSD, early.VolumeUSD)
+	}
+
+	b.add(sellWETH(10, 5, "1", "3000"))
+	memeSwap.Block, memeSwap.Time = 12, candleDay.Add(9*time.Second)
+	b.add(memeSwap)
+
+	meme := bar(t, b, memeWETHPool, "30s", 0)
+	if meme.Base != memeToken || meme.Quote != wethToken {
+		t.Errorf("bar prices %s in %s, want the meme token in WETH", meme.Base.Hex(), meme.Quote.Hex())
+	}
+	if !near(meme.Close, 0.001) || !near(meme.CloseUSD.Float64, 3) || !near(meme.VolumeUSD.Float64, 3000) {
+		t.Errorf("close %v WETH, %v USD, volume %v USD; want 0.001 WETH, 3 USD, 3000 USD", meme.Close, meme.CloseUSD.Float64, meme.VolumeUSD.Float64)
+	}
+	if !meme.OpenUSD.Valid || !near(meme.OpenUSD.Float64, 3) {
+		t.Errorf("USD open = %v, want the first priced swap at 3", meme.OpenUSD)
+	}
+}
+
+func TestCandleBuilderSkipsUnpriceableSwaps(t *testing.T) {
+	b := newCandleBuilder(wethUSDCPool)
+
+	noDecimals := sellWETH(10, 5, "1", "3000")
+	noDecimals.Decimals0 = sql.NullInt64{}
+	noTime := sellWETH(10, 5, "1", "3000")
+	noTime.Time = time.Time{}
+	oneSided := wethUSDCSwap(10, 5) // Only WETH leaves the pool; nothing is traded for it
+	oneSided.Amount0Out = "1000000000000000000"
+
+	for _, swap := range []candleSwap{noDecimals, noTime, oneSided} {
+		b.add(swap)
+	}
+	if b.skipped != 3 || len(b.bars) != 0 {
+		t.Errorf("skipped %d swaps and built %d bars, want 3 skipped and none built", b.skipped, len(b.bars))
+	}
+}
+
+func TestCandleBuilderQueueStartsOver(t *testing.T) {
+	b := newCandleBuilder(wethUSDCPool)
+	b.add(sellWETH(10, 5, "1", "3000"))
+	b.queue()
+	if len(b.bars) != 0 || len(b.order) != 0 || b.skipped != 0 {
+		t.Errorf("queue left %d bars, %d ordered keys and %d skipped swaps", len(b.bars), len(b.order), b.skipped)
+	}
+	if got := len(rowWriter.rows[candlesTable]); got != len(candlePeriods) {
+		t.Errorf("queue added %d bars, want one per period (%d)", got, len(candlePeriods))
+	}
+	if b.ethUSD != 3000 {
+		t.Errorf("queue reset the WETH price to %v, want 3000", b.ethUSD)
+	}
+}
+
+func TestTokenAmount(t *testing.T) {
+	if got := tokenAmount("1500000", 6); got != 1.5 {
+		t.Errorf("tokenAmount(1500000, 6) = %v, want 1.5", got)
+	}
+	if got := tokenAmount("123456789012345678901234567890", 18); !near(got, 123456789012.34567890123456789) {
+		t.Errorf("tokenAmount of a 30 digit amount = %v", got)
+	}
+	if got := tokenAmount("not a number", 18); got != 0 {
+		t.Errorf("tokenAmount of garbage = %v, want 0", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// loadCheckpoint returns the last fully processed block for a scanner, if one was recorded.
+func loadCheckpoint(scanner string) (uint64, bool) {
+	var lastBlock uint64
+	err := db.QueryRow(`SELECT last_block FROM scan_checkpoints WHERE scanner = $1`, scanner).Scan(&lastBlock)
+	if err == sql.ErrNoRows {
+		return 0, false
+	}
+	if err != nil {
+		log.Fatalf("Failed to load checkpoint for %s: %v", scanner, err)
+	}
+	return lastBlock, true
+}
+
+// loadCheckpoints returns the last fully processed block of every scanner whose name starts with prefix.
+func loadCheckpoints(prefix string) map[string]uint64 {
+	rows, err := db.Query(`SELECT scanner, last_block FROM scan_checkpoints WHERE starts_with(scanner, $1)`, prefix)
+	if err != nil {
+		log.Fatalf("Failed to load checkpoints for %s: %v", prefix, err)
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string]uint64)
+	for rows.Next() {
+		var scanner string
+		var lastBlock uint64
+		if err := rows.Scan(&scanner, &lastBlock); err != nil {
+			log.Fatalf("Failed to load checkpoints for %s: %v", prefix, err)
+		}
+		checkpoints[scanner] = lastBlock
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to load checkpoints for %s: %v", prefix, err)
+	}
+	return checkpoints
+}
+
+// saveCheckpoint records that every block up to and including lastBlock has been processed.
+func saveCheckpoint(scanner string, lastBlock uint64) {
+	if err := writeCheckpoint(db, scanner, lastBlock); err != nil {
+		log.Fatalf("Failed to save checkpoint for %s: %v", scanner, err)
+	}
+}
+
+// writeCheckpoint stores a checkpoint through exec, which is either the database or a transaction
+// that also stores the rows of the checkpointed range.
+func writeCheckpoint(exec execer, scanner string, lastBlock uint64) error {
+	query := `
+        INSERT INTO scan_checkpoints (scanner, last_block, updated_at)
+        VALUES ($1, $2, now())
+        ON CONFLICT (scanner) DO UPDATE SET last_block = EXCLUDED.last_block, updated_at = now()
+    `
+	_, err := exec.Exec(query, scanner, lastBlock)
+	return err
+}
+
+// scannersBehind returns the scanners whose checkpoint is before block. Scanners that share a scan
+// but are further along, such as the other event groups while a new one catches up, are left out,
+// so their checkpoints never move back outside a rewind.
+func scannersBehind(scanners []string, block uint64) []string {
+	var behind []string
+	for _, scanner := range scanners {
+		if lastBlock, ok := loadCheckpoint(scanner); !ok || lastBlock < block {
+			behind = append(behind, scanner)
+		}
+	}
+	return behind
+}
+
+// rewindCheckpoint moves a scanner back so that its next run starts at block. Moving a checkpoint
+// forward would skip the blocks in between, so it is refused; a scanner without a checkpoint
+// starts from the beginning anyway and is left alone.
+func rewindCheckpoint(scanner string, block uint64) error {
+	lastBlock, ok := loadCheckpoint(scanner)
+	if !ok {
+		log.Printf("No checkpoint for %s, nothing to rewind", scanner)
+		return nil
+	}
+	if block > lastBlock+1 {
+		return fmt.Errorf("%s has only processed up to block %d, so starting at block %d would skip blocks", scanner, lastBlock, block)
+	}
+
+	if block == 0 {
+		if _, err := db.Exec(`DELETE FROM scan_checkpoints WHERE scanner = $1`, scanner); err != nil {
+			log.Fatalf("Failed to clear checkpoint for %s: %v", scanner, err)
+		}
+		log.Printf("Cleared checkpoint for %s", scanner)
+		return nil
+	}
+	saveCheckpoint(scanner, block-1)
+	log.Printf("Rewound %s to block %d", scanner, block)
+	return nil
+}
+
+// resumeBlock returns the block a scanner should start from: the block after its
+// checkpoint, or startBlock if nothing has been processed yet.
+func resumeBlock(scanner string, startBlock uint64) uint64 {
+	lastBlock, ok := loadCheckpoint(scanner)
+	if !ok || lastBlock+1 < startBlock {
+		return startBlock
+	}
+	log.Printf("Resuming %s from checkpoint at block %d", scanner, lastBlock)
+	return lastBlock + 1
+}
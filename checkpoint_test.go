@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// useCheckpointTable points db at a fake holding only scan_checkpoints, kept in the returned map.
+func useCheckpointTable(t *testing.T) map[string]uint64 {
+	checkpoints := make(map[string]uint64)
+	fake := useFakeDB(t)
+	fake.query = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if lastBlock, ok := checkpoints[args[0].(string)]; ok {
+			return []string{"last_block"}, [][]driver.Value{{int64(lastBlock)}}, nil
+		}
+		return []string{"last_block"}, nil, nil
+	}
+	fake.exec = func(query string, args []driver.Value) (int64, error) {
+		scanner := args[0].(string)
+		if strings.Contains(query, "DELETE") {
+			delete(checkpoints, scanner)
+		} else {
+			checkpoints[scanner] = uint64(args[1].(int64))
+		}
+		return 1, nil
+	}
+	return checkpoints
+}
+
+func TestResumeBlock(t *testing.T) {
+	checkpoints := useCheckpointTable(t)
+
+	if got := resumeBlock("topic_monitor", 1500000); got != 1500000 {
+		t.Errorf("resumeBlock without a checkpoint = %d, want the start block", got)
+	}
+	saveCheckpoint("topic_monitor", 1600000)
+	if checkpoints["topic_monitor"] != 1600000 {
+		t.Fatalf("saveCheckpoint stored %v", checkpoints)
+	}
+	if got := resumeBlock("topic_monitor", 1500000); got != 1600001 {
+		t.Errorf("resumeBlock after a checkpoint at 1600000 = %d, want 1600001", got)
+	}
+
+	// A start block past the checkpoint wins, as when a factory's deployment block was found later
+	if got := resumeBlock("topic_monitor", 1700000); got != 1700000 {
+		t.Errorf("resumeBlock with a later start block = %d, want 1700000", got)
+	}
+}
+
+func TestRewindCheckpoint(t *testing.T) {
+	checkpoints := useCheckpointTable(t)
+	checkpoints["topic_monitor"] = 2000000
+
+	if err := rewindCheckpoint("topic_monitor", 1800000); err != nil || checkpoints["topic_monitor"] != 1799999 {
+		t.Errorf("rewind to 1800000 = %v with the checkpoint at %d, want it at 1799999", err, checkpoints["topic_monitor"])
+	}
+
+	// Restarting right after the checkpoint changes nothing; anything later would skip blocks
+	if err := rewindCheckpoint("topic_monitor", 1800000); err != nil || checkpoints["topic_monitor"] != 1799999 {
+		t.Errorf("rewind to the next block = %v with the checkpoint at %d, want it kept at 1799999", err, checkpoints["topic_monitor"])
+	}
+	if err := rewindCheckpoint("topic_monitor", 1800001); err == nil || checkpoints["topic_monitor"] != 1799999 {
+		t.Errorf("rewind forward = %v with the checkpoint at %d, want it refused", err, checkpoints["topic_monitor"])
+	}
+
+	if err := rewindCheckpoint("topic_monitor", 0); err != nil {
+		t.Errorf("rewind to block 0 = %v", err)
+	}
+	if _, ok := checkpoints["topic_monitor"]; ok {
+		t.Errorf("rewind to block 0 kept the checkpoint at %d, want it cleared", checkpoints["topic_monitor"])
+	}
+
+	// Without a checkpoint the scan starts from the beginning, and no checkpoint is made up
+	if err := rewindCheckpoint("topic_monitor", 1800000); err != nil || len(checkpoints) != 0 {
+		t.Errorf("rewind without a checkpoint = %v and left %v, want nothing stored", err, checkpoints)
+	}
+}
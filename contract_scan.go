@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const contractAddressBatch = 500 // Contract addresses per eth_getLogs filter
+
+// contractSet holds the addresses of the contracts a monitor indexes, such as the pools or the
+// tokens, loading the contracts first seen since the last call as the scan advances.
+type contractSet struct {
+	table         string // Table listing the contracts
+	addressColumn string
+	blockColumn   string // Block the contract was first seen at; NULL rows are always included
+
+	addresses []common.Address
+	known     map[common.Address]bool // Contracts in addresses
+	loaded    bool
+	loadedTo  uint64
+}
+
+// upTo returns every contract first seen at or before block, and the ones among them that were
+// not returned by earlier calls. Each call past the loaded block reads every contract up to block
+// again, because a first-seen block can be lowered after it was passed, as when a token turns up in
+// an older pool. After a rollback it may also return contracts first seen slightly later, which is
+// harmless as they have no logs before they exist.
+func (s *contractSet) upTo(block uint64) (all, added []common.Address) {
+	if s.loaded && block <= s.loadedTo {
+		return s.addresses, nil
+	}
+
+	query := fmt.Sprintf(`SELECT %[1]s FROM %[2]s WHERE chain_id = $1 AND (%[3]s IS NULL OR %[3]s <= $2)`,
+		s.addressColumn, s.table, s.blockColumn)
+	rows, err := db.Query(query, chainID, block)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", s.table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			log.Fatalf("Failed to load %s: %v", s.table, err)
+		}
+		contract := common.HexToAddress(address)
+		if !s.known[contract] {
+			added = append(added, contract)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to load %s: %v", s.table, err)
+	}
+	if s.known == nil {
+		s.known = make(map[common.Address]bool)
+	}
+	for _, contract := range added {
+		s.known[contract] = true
+	}
+	s.addresses = append(s.addresses, added...)
+	s.loaded, s.loadedTo = true, block
+	return s.addresses, added
+}
+
+// fetchContractLogs returns the logs with the given topics emitted by the contracts in
+// [fromBlock, toBlock], in chain order. Contracts are queried in batches of addresses.
+func fetchContractLogs(client *RPCPool, pager *logPager, addresses []common.Address, topics []common.Hash, fromBlock, toBlock uint64) []types.Log {
+	query := ethereum.FilterQuery{Topics: [][]common.Hash{topics}}
+
+	var logs []types.Log
+	for start := 0; start < len(addresses); start += contractAddressBatch {
+		end := start + contractAddressBatch
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+		query.Addresses = addresses[start:end]
+		batch, err := pager.filterLogs(context.Background(), client, query, fromBlock, toBlock)
+		if err != nil {
+			log.Fatalf("Failed to filter logs: %v", err)
+		}
+		logs = append(logs, batch...)
+	}
+
+	// Batches return logs per address; restore chain order
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].BlockNumber != logs[j].BlockNumber {
+			return logs[i].BlockNumber < logs[j].BlockNumber
+		}
+		return logs[i].Index < logs[j].Index
+	})
+	return logs
+}
+
+// commitEventLogs stores the rows of the logs of [fromBlock, toBlock] and runs the groups'
+// BeforeCommit hooks in one transaction, which also checkpoints the given scanners that are behind
+// toBlock. Logs that fail to decode are quarantined. The block timestamps of the page, and the
+// senders groups need, are fetched in batches first.
+func commitEventLogs(logs []types.Log, groups []*eventGroup, scanners []string, fromBlock, toBlock uint64) {
+	prefetchBlockTimes(logs)
+	var senderLogs []types.Log
+	for _, vLog := range logs {
+		if len(vLog.Topics) == 0 {
+			continue
+		}
+		if decoder, ok := eventDecoders[vLog.Topics[0]]; ok && decoder.Group.Senders {
+			senderLogs = append(senderLogs, vLog)
+		}
+	}
+	prefetchSenders(senderLogs)
+
+	for _, vLog := range logs {
+		if err := handleEventLog(vLog); err != nil {
+			quarantineLog(vLog, err)
+		}
+	}
+
+	for _, group := range groups {
+		if hook := group.BeforeCommit; hook != nil {
+			rowWriter.inTransaction(func(tx *sql.Tx) error {
+				return hook(tx, fromBlock, toBlock)
+			})
+		}
+	}
+	rowWriter.flush(toBlock, scannersBehind(scanners, toBlock)...)
+}
+
+// contractMonitor indexes the event groups emitted by a set of contracts found through the pools,
+// such as the pools themselves or their tokens.
+type contractMonitor struct {
+	scanner      string // Reorg guard name; each group checkpoints as <scanner>:<group>
+	groups       []*eventGroup
+	contracts    *contractSet
+	poolsScanner string // Scanner that indexes the pools; events are indexed up to its checkpoint
+	startBlock   uint64 // Block groups without a checkpoint start from
+
+	headTag       string
+	confirmations uint64
+
+	// Indexes the groups' events emitted by the contracts in [fromBlock, toBlock] and checkpoints the groups
+	scanRange func(client *RPCPool, pager *logPager, contracts *contractSet, groups []*eventGroup, fromBlock, toBlock uint64)
+}
+
+// run indexes the groups up to the pools scanner's checkpoint and then, with follow, keeps
+// following the chain head.
+func (m *contractMonitor) run(client *RPCPool, follow bool) {
+	// The contracts are found through their pools, so their events can only be indexed once the pools have been
+	poolsIndexed := func() uint64 {
+		lastBlock, ok := loadCheckpoint(m.poolsScanner)
+		if !ok {
+			log.Fatalf("No checkpoint for %s; index the pools first", m.poolsScanner)
+		}
+		return lastBlock
+	}
+	head := func(ctx context.Context) (uint64, error) {
+		confirmed, err := confirmedHead(ctx, client, m.headTag, m.confirmations)
+		if err != nil {
+			return 0, err
+		}
+		if indexed := poolsIndexed(); indexed < confirmed {
+			return indexed, nil
+		}
+		return confirmed, nil
+	}
+
+	endBlock := poolsIndexed()
+	if follow {
+		var err error
+		if endBlock, err = head(context.Background()); err != nil {
+			log.Fatalf("Failed to fetch the chain head: %v", err)
+		}
+	}
+
+	// Groups resume from the earliest of their checkpoints; groups that are further along simply
+	// rewrite the same rows until the others catch up, and keep their checkpoints
+	fromBlock := endBlock + 1
+	for _, group := range m.groups {
+		if resume := resumeBlock(groupScanner(m.scanner, group), m.startBlock); resume < fromBlock {
+			fromBlock = resume
+		}
+	}
+
+	pager := newLogPager()
+	for fromBlock <= endBlock {
+		toBlock := pager.pageEnd(fromBlock, endBlock)
+		m.scanRange(client, pager, m.contracts, m.groups, fromBlock, toBlock)
+		fromBlock = toBlock + 1
+	}
+	if !follow {
+		return
+	}
+
+	log.Println("Historical range done, following the chain head...")
+	ctx := context.Background()
+
+	scanners := groupScanners(m.scanner, m.groups)
+	var tables []string
+	for _, group := range m.groups {
+		tables = append(tables, group.Tables...)
+	}
+	checkpoint := func(lastBlock uint64) {
+		rowWriter.flush(lastBlock, scanners...)
+	}
+	guard := &reorgGuard{scanner: m.scanner, client: client, tables: tables, checkpoint: checkpoint}
+	nextBlock := endBlock + 1
+	guard.recordIndexed(ctx, nextBlock-1)
+
+	// The address lists are too long for a websocket subscription, so new blocks are polled
+	f := &follower{
+		guard: guard,
+		head:  head,
+		scanRange: func(fromBlock, toBlock uint64) {
+			m.scanRange(client, pager, m.contracts, m.groups, fromBlock, toBlock)
+		},
+		checkpoint: checkpoint,
+	}
+	f.run(ctx, nextBlock)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestContractSetUpTo(t *testing.T) {
+	tokenA := common.HexToAddress("0x4200000000000000000000000000000000000006")
+	tokenB := common.HexToAddress("0x833589fCD6eDb6E08f4c7C32D4f71b54bdA02913")
+	tokenC := common.HexToAddress("0x50c5725949A6F0c72E6C4a641F24049A917DB0Cb")
+
+	// tokenB has no first-seen block, so it is always included
+	firstSeen := map[common.Address]interface{}{tokenA: int64(100), tokenB: nil, tokenC: int64(500)}
+	fake := useFakeDB(t)
+	fake.query = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		var rows [][]driver.Value
+		for _, token := range []common.Address{tokenA, tokenB, tokenC} {
+			if block, ok := firstSeen[token].(int64); !ok || block <= args[1].(int64) {
+				rows = append(rows, []driver.Value{token.Hex()})
+			}
+		}
+		return []string{"address"}, rows, nil
+	}
+	tokens := &contractSet{table: "tokens", addressColumn: "address", blockColumn: "first_seen_block"}
+
+	all, added := tokens.upTo(200)
+	if fmt.Sprint(all) != fmt.Sprint([]common.Address{tokenA, tokenB}) || fmt.Sprint(added) != fmt.Sprint(all) {
+		t.Errorf("first upTo(200) = %v, %v; want tokenA and tokenB, both added", all, added)
+	}
+	if all, added = tokens.upTo(150); len(all) != 2 || added != nil || len(fake.executed()) != 1 {
+		t.Errorf("upTo(150) below the loaded block = %v, %v after %d queries; want the loaded tokens without a query",
+			all, added, len(fake.executed()))
+	}
+
+	// An older pool lowers tokenC's first-seen block below the block already loaded
+	firstSeen[tokenC] = int64(120)
+	all, added = tokens.upTo(300)
+	if len(all) != 3 || fmt.Sprint(added) != fmt.Sprint([]common.Address{tokenC}) {
+		t.Errorf("upTo(300) after tokenC was lowered = %v, %v; want only tokenC added", all, added)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+
+	_ "github.com/lib/pq"
+)
+
+var db *sql.DB
+
+// execer runs a statement on either the database or a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func initDB() {
+	// Set up the database connection.
+
+	connStr := "user=emmett dbname=cryptoarch sslmode=disable password=password"
+	var err error
+	db, err = sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+}
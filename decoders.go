@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// PoolType tells consumers which pricing and liquidity model a pool follows.
+type PoolType string
+
+const (
+	poolTypeV2              PoolType = "v2"               // Constant product x*y=k
+	poolTypeV3              PoolType = "v3"               // Concentrated liquidity with a fee tier and tick spacing
+	poolTypeSolidlyStable   PoolType = "solidly-stable"   // Solidly x^3*y+y^3*x=k curve
+	poolTypeSolidlyVolatile PoolType = "solidly-volatile" // Solidly pool using x*y=k
+)
+
+// PoolRecord is a newly created pool, normalized across the events DEX factories emit.
+type PoolRecord struct {
+	Pool        common.Address
+	Token0      common.Address
+	Token1      common.Address
+	Type        PoolType
+	Stable      bool           // Solidly stable pool; priced on the stable curve rather than x*y=k
+	Fee         uint32         // Fee tier in hundredths of a basis point; concentrated-liquidity pools only
+	TickSpacing int32          // Concentrated-liquidity pools only
+	Factory     common.Address // The contract that emitted the event
+	Event       string         // Signature of the event that announced the pool
+	Log         types.Log
+}
+
+// isConcentrated reports whether the pool carries a fee tier and tick spacing.
+func (r PoolRecord) isConcentrated() bool {
+	return r.Type == poolTypeV3
+}
+
+// poolDecoder turns one kind of pool-creation log into a PoolRecord.
+type poolDecoder struct {
+	Event  abi.Event                                               // ABI definition of the event; Event.ID is the log's first topic
+	Decode func(values map[string]interface{}) (PoolRecord, error) // Builds the record from the decoded arguments
+}
+
+// poolDecoders holds every registered pool-creation event by topic.
+var poolDecoders = make(map[common.Hash]*poolDecoder)
+
+// registerPoolDecoder adds the decoder for an event given in Solidity syntax, e.g.
+// "event PairCreated(address indexed token0, address indexed token1, address pair, uint256)".
+func registerPoolDecoder(definition string, decode func(values map[string]interface{}) (PoolRecord, error)) {
+	event, err := parseEventDefinition(definition)
+	if err != nil {
+		panic(fmt.Sprintf("invalid event definition %q: %v", definition, err))
+	}
+	if _, exists := poolDecoders[event.ID]; exists {
+		panic("pool decoder registered twice: " + event.Sig)
+	}
+	poolDecoders[event.ID] = &poolDecoder{Event: event, Decode: decode}
+}
+
+// parseEventDefinition builds an abi.Event from its Solidity declaration.
+func parseEventDefinition(definition string) (abi.Event, error) {
+	definition = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(definition), "event "))
+	open, close := strings.Index(definition, "("), strings.LastIndex(definition, ")")
+	if open <= 0 || close < open {
+		return abi.Event{}, fmt.Errorf("expected Name(arguments)")
+	}
+	name := definition[:open]
+
+	var inputs abi.Arguments
+	if params := strings.TrimSpace(definition[open+1 : close]); params != "" {
+		for _, param := range strings.Split(params, ",") {
+			fields := strings.Fields(param)
+			if len(fields) == 0 {
+				return abi.Event{}, fmt.Errorf("empty argument")
+			}
+			typ, err := abi.NewType(fields[0], "", nil)
+			if err != nil {
+				return abi.Event{}, err
+			}
+			argument := abi.Argument{Type: typ}
+			for _, field := range fields[1:] {
+				if field == "indexed" {
+					argument.Indexed = true
+				} else {
+					argument.Name = field
+				}
+			}
+			inputs = append(inputs, argument)
+		}
+	}
+	return abi.NewEvent(name, name, false, inputs), nil
+}
+
+// poolCreationTopics returns the topics of every registered event, for use in a log filter.
+func poolCreationTopics() []common.Hash {
+	topics := make([]common.Hash, 0, len(poolDecoders))
+	for topic := range poolDecoders {
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool { return bytes.Compare(topics[i][:], topics[j][:]) < 0 })
+	return topics
+}
+
+// decodePoolLog decodes a log with the decoder registered for its first topic.
+func decodePoolLog(vLog types.Log) (PoolRecord, error) {
+	if len(vLog.Topics) == 0 {
+		return PoolRecord{}, fmt.Errorf("log has no topics")
+	}
+	decoder, ok := poolDecoders[vLog.Topics[0]]
+	if !ok {
+		return PoolRecord{}, fmt.Errorf("no decoder registered for topic %s", vLog.Topics[0].Hex())
+	}
+
+	values, err := unpackEvent(decoder.Event, vLog)
+	if err != nil {
+		return PoolRecord{}, fmt.Errorf("%s: %v", decoder.Event.Sig, err)
+	}
+	record, err := decoder.Decode(values)
+	if err != nil {
+		return PoolRecord{}, fmt.Errorf("%s: %v", decoder.Event.Sig, err)
+	}
+	record.Factory = vLog.Address
+	record.Event = decoder.Event.Sig
+	record.Log = vLog
+	return record, nil
+}
+
+// unpackEvent decodes the indexed and data arguments of a log into a map keyed by argument name,
+// rejecting logs whose shape does not match the event definition exactly.
+func unpackEvent(event abi.Event, vLog types.Log) (map[string]interface{}, error) {
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if len(vLog.Topics) != len(indexed)+1 {
+		return nil, fmt.Errorf("expected %d topics, got %d", len(indexed)+1, len(vLog.Topics))
+	}
+
+	// Addresses and booleans are left-padded with zeros; anything else means the log is not this event
+	for i, input := range indexed {
+		topic := vLog.Topics[i+1]
+		switch input.Type.T {
+		case abi.AddressTy:
+			if !isZero(topic[:12]) {
+				return nil, fmt.Errorf("topic %d is not a valid address", i+1)
+			}
+		case abi.BoolTy:
+			if !isZero(topic[:31]) || topic[31] > 1 {
+				return nil, fmt.Errorf("topic %d is not a valid bool", i+1)
+			}
+		}
+	}
+
+	nonIndexed := event.Inputs.NonIndexed()
+	if want := 32 * len(nonIndexed); len(vLog.Data) != want {
+		return nil, fmt.Errorf("expected %d bytes of data, got %d", want, len(vLog.Data))
+	}
+
+	values := make(map[string]interface{})
+	if err := nonIndexed.UnpackIntoMap(values, vLog.Data); err != nil {
+		return nil, err
+	}
+	if err := abi.ParseTopicsIntoMap(values, indexed, vLog.Topics[1:]); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// isZero reports whether every byte of b is zero.
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// addressValue returns the decoded address argument with the given name, which must not be zero.
+func addressValue(values map[string]interface{}, name string) (common.Address, error) {
+	address, err := anyAddressValue(values, name)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if address == (common.Address{}) {
+		return common.Address{}, fmt.Errorf("argument %s is the zero address", name)
+	}
+	return address, nil
+}
+
+// anyAddressValue returns the decoded address argument with the given name, which may be zero.
+func anyAddressValue(values map[string]interface{}, name string) (common.Address, error) {
+	address, ok := values[name].(common.Address)
+	if !ok {
+		return common.Address{}, fmt.Errorf("argument %s is not an address", name)
+	}
+	return address, nil
+}
+
+// intValue returns the decoded integer argument with the given name, which must lie in [min, max].
+func intValue(values map[string]interface{}, name string, min, max int64) (int64, error) {
+	value, ok := values[name].(*big.Int)
+	if !ok {
+		return 0, fmt.Errorf("argument %s is not an integer", name)
+	}
+	if !value.IsInt64() || value.Int64() < min || value.Int64() > max {
+		return 0, fmt.Errorf("argument %s is out of range: %s", name, value)
+	}
+	return value.Int64(), nil
+}
+
+// bigValue returns the decoded integer argument with the given name without limiting its range.
+func bigValue(values map[string]interface{}, name string) (*big.Int, error) {
+	value, ok := values[name].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("argument %s is not an integer", name)
+	}
+	return value, nil
+}
+
+// boolValue returns the decoded bool argument with the given name.
+func boolValue(values map[string]interface{}, name string) (bool, error) {
+	value, ok := values[name].(bool)
+	if !ok {
+		return false, fmt.Errorf("argument %s is not a bool", name)
+	}
+	return value, nil
+}
+
+// poolFields returns a decoder reading the two tokens and the pool from the named arguments.
+func poolFields(token0, token1, pool string) func(values map[string]interface{}) (PoolRecord, error) {
+	return func(values map[string]interface{}) (PoolRecord, error) {
+		var record PoolRecord
+		var err error
+		if record.Token0, err = addressValue(values, token0); err != nil {
+			return record, err
+		}
+		if record.Token1, err = addressValue(values, token1); err != nil {
+			return record, err
+		}
+		if record.Pool, err = addressValue(values, pool); err != nil {
+			return record, err
+		}
+		if record.Token0 == record.Token1 {
+			return record, fmt.Errorf("both tokens are %s", record.Token0.Hex())
+		}
+		return record, nil
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	baseWETH  = common.HexToAddress("0x4200000000000000000000000000000000000006")
+	baseUSDC  = common.HexToAddress("0x833589fcd6edb6e08f4c7c32d4f71b54bda02913")
+	baseCbETH = common.HexToAddress("0x2ae3f1ec7f1f5012cfeab0185bfc7aa3cf0dec22")
+)
+
+// Pool-creation logs of every registered event, as eth_getLogs returns them.
+var poolCreationLogs = map[string]string{
+	"Uniswap V2 PairCreated": `{
+		"address": "0x8909dc15e40173ff4699343b6eb8132c65e18ec6",
+		"topics": [
+			"0x0d3648bd0f6ba80134a33ba9275ac585d9d315f0ad8355cddefde31afa28d0e9",
+			"0x0000000000000000000000004200000000000000000000000000000000000006",
+			"0x000000000000000000000000833589fcd6edb6e08f4c7c32d4f71b54bda02913"
+		],
+		"data": "0x00000000000000000000000088a43bbdf9d098eec7bceda4e2494615dfd9bb9c0000000000000000000000000000000000000000000000000000000000000002",
+		"blockNumber": "0x22da00",
+		"transactionHash": "0x54da747f0235840256f862692cfc214f35622cff5a98f688cb6b13fabb4f453a",
+		"transactionIndex": "0x1",
+		"blockHash": "0x67ffe8ed405c61be911f1e304a4a30ec32c41bcff1ff4bcf85a9d516d2768f3a",
+		"logIndex": "0x0",
+		"removed": false
+	}`,
+	"Uniswap V3 PoolCreated": `{
+		"address": "0x33128a8fc17869897dce68ed026d694621f6fdfd",
+		"topics": [
+			"0x783cca1c0412dd0d695e784568c96da2e9c22ff989357a2e8b1d9b2b4e6b7118",
+			"0x0000000000000000000000004200000000000000000000000000000000000006",
+			"0x000000000000000000000000833589fcd6edb6e08f4c7c32d4f71b54bda02913",
+			"0x00000000000000000000000000000000000000000000000000000000000001f4"
+		],
+		"data": "0x000000000000000000000000000000000000000000000000000000000000000a000000000000000000000000d0b53d9277642d899df5c87a3966a349a798f224",
+		"blockNumber": "0x14ee42",
+		"transactionHash": "0xb61cadd0d1302a28eacb0f51a31d6c148cfc783bd81964c5642a440885d7868a",
+		"transactionIndex": "0x2",
+		"blockHash": "0x8e5f7327625c922ee6d2503dcd499cca5d81078dfcc95d7344684d001de550aa",
+		"logIndex": "0x3",
+		"removed": false
+	}`,
+	"iZiSwap NewPool": `{
+		"address": "0x8c7d3063579bdb0b90997e18a770eae32e1ebb08",
+		"topics": [
+			"0xf04da67755adf58739649e2fb9949a6328518141b7ac9e44aa10320688b04900",
+			"0x0000000000000000000000004200000000000000000000000000000000000006",
+			"0x000000000000000000000000833589fcd6edb6e08f4c7c32d4f71b54bda02913",
+			"0x0000000000000000000000000000000000000000000000000000000000000bb8"
+		],
+		"data": "0x000000000000000000000000000000000000000000000000000000000000003c0000000000000000000000005c9ba9bd28b8c4b3df1be6f0bcd6b6f4a3e7c3f1",
+		"blockNumber": "0x2c4d7d",
+		"transactionHash": "0x032c0f07152f495c725a40b2710f0f12787d7f24b400461703c4e8074f0e14e8",
+		"transactionIndex": "0x3",
+		"blockHash": "0xe6faac9a6b582fe562c84f184e01f71b79d468b337ff15114fd2542356f18c77",
+		"logIndex": "0x6",
+		"removed": false
+	}`,
+	"Solidly PairCreated": `{
+		"address": "0xfda619b6d20975be80a10332cd39b9a4b0faa8bb",
+		"topics": [
+			"0xc4805696c66d7cf352fc1d6bb633ad5ee82f6cb577c453024b6e0eb8306c6fc9",
+			"0x000000000000000000000000833589fcd6edb6e08f4c7c32d4f71b54bda02913",
+			"0x0000000000000000000000002ae3f1ec7f1f5012cfeab0185bfc7aa3cf0dec22"
+		],
+		"data": "0x00000000000000000000000000000000000000000000000000000000000000010000000000000000000000003c6e4b2bda7f1a0b4fa5b2ae1b1e5d1a5a3fbd1c000000000000000000000000000000000000000000000000000000000000000e",
+		"blockNumber": "0x2f6072",
+		"transactionHash": "0xe3225ad5cbd410f236ef413f7d0ebedc3f6d54da39e3376aab2bc95ce9e9f67c",
+		"transactionIndex": "0x4",
+		"blockHash": "0x53f86b7a6094c8f557535d3b10734193ec6436a98b7068bb9540f0da5c81bd84",
+		"logIndex": "0x9",
+		"removed": false
+	}`,
+	"Aerodrome PoolCreated": `{
+		"address": "0x420dd381b31aef6683db6b902084cb0ffece40da",
+		"topics": [
+			"0x2128d88d14c80cb081c1252a5acff7a264671bf199ce226b53788fb26065005e",
+			"0x0000000000000000000000004200000000000000000000000000000000000006",
+			"0x000000000000000000000000833589fcd6edb6e08f4c7c32d4f71b54bda02913",
+			"0x0000000000000000000000000000000000000000000000000000000000000000"
+		],
+		"data": "0x000000000000000000000000cdac0d6c6c59727a65f871236188350531885c430000000000000000000000000000000000000000000000000000000000000003",
+		"blockNumber": "0x30d637",
+		"transactionHash": "0xd7bb70f7100854c31cbb4a42335aa2bd2d66ce90009cba0c46d40802dd107eb1",
+		"transactionIndex": "0x5",
+		"blockHash": "0xaa00df8bf0d5bb7f28edc81ae92bff24c0e8f2186c24dfee2a6646a59e64fe67",
+		"logIndex": "0xc",
+		"removed": false
+	}`,
+}
+
+// The records the fixtures decode to, apart from the Log they carry.
+var poolCreationRecords = map[string]PoolRecord{
+	"Uniswap V2 PairCreated": {
+		Pool:    common.HexToAddress("0x88a43bbdf9d098eec7bceda4e2494615dfd9bb9c"),
+		Token0:  baseWETH,
+		Token1:  baseUSDC,
+		Type:    poolTypeV2,
+		Factory: common.HexToAddress("0x8909dc15e40173ff4699343b6eb8132c65e18ec6"),
+		Event:   "PairCreated(address,address,address,uint256)",
+	},
+	"Uniswap V3 PoolCreated": {
+		Pool:        common.HexToAddress("0xd0b53d9277642d899df5c87a3966a349a798f224"),
+		Token0:      baseWETH,
+		Token1:      baseUSDC,
+		Type:        poolTypeV3,
+		Fee:         500,
+		TickSpacing: 10,
+		Factory:     common.HexToAddress("0x33128a8fc17869897dce68ed026d694621f6fdfd"),
+		Event:       "PoolCreated(address,address,uint24,int24,address)",
+	},
+	"iZiSwap NewPool": {
+		Pool:        common.HexToAddress("0x5c9ba9bd28b8c4b3df1be6f0bcd6b6f4a3e7c3f1"),
+		Token0:      baseWETH,
+		Token1:      baseUSDC,
+		Type:        poolTypeV3,
+		Fee:         3000,
+		TickSpacing: 60,
+		Factory:     common.HexToAddress("0x8c7d3063579bdb0b90997e18a770eae32e1ebb08"),
+		Event:       "NewPool(address,address,uint24,uint24,address)",
+	},
+	"Solidly PairCreated": {
+		Pool:    common.HexToAddress("0x3c6e4b2bda7f1a0b4fa5b2ae1b1e5d1a5a3fbd1c"),
+		Token0:  baseUSDC,
+		Token1:  baseCbETH,
+		Type:    poolTypeSolidlyStable,
+		Stable:  true,
+		Factory: common.HexToAddress("0xfda619b6d20975be80a10332cd39b9a4b0faa8bb"),
+		Event:   "PairCreated(address,address,bool,address,uint256)",
+	},
+	"Aerodrome PoolCreated": {
+		Pool:    common.HexToAddress("0xcdac0d6c6c59727a65f871236188350531885c43"),
+		Token0:  baseWETH,
+		Token1:  baseUSDC,
+		Type:    poolTypeSolidlyVolatile,
+		Factory: common.HexToAddress("0x420dd381b31aef6683db6b902084cb0ffece40da"),
+		Event:   "PoolCreated(address,address,bool,address,uint256)",
+	},
+}
+
+// poolCreationLog parses the named fixture.
+func poolCreationLog(t *testing.T, name string) types.Log {
+	t.Helper()
+	var vLog types.Log
+	if err := json.Unmarshal([]byte(poolCreationLogs[name]), &vLog); err != nil {
+		t.Fatalf("fixture %s: %v", name, err)
+	}
+	return vLog
+}
+
+// decodeWithoutPanic decodes vLog and fails the test if the decoder panics instead of returning an error.
+func decodeWithoutPanic(t *testing.T, vLog types.Log) (record PoolRecord, err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("decodePoolLog panicked: %v", r)
+		}
+	}()
+	return decodePoolLog(vLog)
+}
+
+// addressTopic returns the topic an indexed address argument is logged as.
+func addressTopic(address common.Address) common.Hash {
+	return common.BytesToHash(address.Bytes())
+}
+
+func TestDecodePoolLog(t *testing.T) {
+	if len(poolCreationLogs) != len(poolDecoders) {
+		t.Errorf("%d fixtures for %d registered events", len(poolCreationLogs), len(poolDecoders))
+	}
+	for name := range poolCreationLogs {
+		t.Run(name, func(t *testing.T) {
+			vLog := poolCreationLog(t, name)
+			record, err := decodeWithoutPanic(t, vLog)
+			if err != nil {
+				t.Fatalf("decodePoolLog failed: %v", err)
+			}
+			if !reflect.DeepEqual(record.Log, vLog) {
+				t.Errorf("record carries log %+v, want %+v", record.Log, vLog)
+			}
+			record.Log = types.Log{}
+			if want := poolCreationRecords[name]; !reflect.DeepEqual(record, want) {
+				t.Errorf("decodePoolLog = %+v, want %+v", record, want)
+			}
+		})
+	}
+}
+
+func TestDecodePoolLogRejectsDamagedLogs(t *testing.T) {
+	damages := map[string]func(vLog *types.Log){
+		"without topics":          func(vLog *types.Log) { vLog.Topics = nil },
+		"only the event topic":    func(vLog *types.Log) { vLog.Topics = vLog.Topics[:1] },
+		"last topic missing":      func(vLog *types.Log) { vLog.Topics = vLog.Topics[:len(vLog.Topics)-1] },
+		"extra topic":             func(vLog *types.Log) { vLog.Topics = append(vLog.Topics, common.Hash{}) },
+		"dirty address topic":     func(vLog *types.Log) { vLog.Topics[1][0] = 1 },
+		"unknown event":           func(vLog *types.Log) { vLog.Topics[0] = common.HexToHash("0xdead") },
+		"without data":            func(vLog *types.Log) { vLog.Data = nil },
+		"data cut mid-word":       func(vLog *types.Log) { vLog.Data = vLog.Data[:len(vLog.Data)-1] },
+		"last data word missing":  func(vLog *types.Log) { vLog.Data = vLog.Data[:len(vLog.Data)-32] },
+		"extra data word":         func(vLog *types.Log) { vLog.Data = append(vLog.Data, make([]byte, 32)...) },
+		"both tokens the same":    func(vLog *types.Log) { vLog.Topics[2] = vLog.Topics[1] },
+		"token0 the zero address": func(vLog *types.Log) { vLog.Topics[1] = common.Hash{} },
+	}
+	for name := range poolCreationLogs {
+		for damage, apply := range damages {
+			vLog := poolCreationLog(t, name)
+			apply(&vLog)
+			if _, err := decodeWithoutPanic(t, vLog); err == nil {
+				t.Errorf("%s %s: decodePoolLog succeeded, want an error", name, damage)
+			}
+		}
+	}
+}
+
+func TestDecodePoolLogRejectsZeroPool(t *testing.T) {
+	// Data word holding the pool address
+	poolWord := map[string]int{
+		"Uniswap V2 PairCreated": 0,
+		"Uniswap V3 PoolCreated": 1,
+		"iZiSwap NewPool":        1,
+		"Solidly PairCreated":    1,
+		"Aerodrome PoolCreated":  0,
+	}
+	for name, word := range poolWord {
+		vLog := poolCreationLog(t, name)
+		copy(vLog.Data[32*word:32*(word+1)], make([]byte, 32))
+		if _, err := decodeWithoutPanic(t, vLog); err == nil || !strings.Contains(err.Error(), "zero address") {
+			t.Errorf("%s with a zero pool: decodePoolLog = %v, want a zero address error", name, err)
+		}
+	}
+}
+
+func TestDecodePoolLogChecksPoolParameters(t *testing.T) {
+	v3 := poolCreationLog(t, "Uniswap V3 PoolCreated")
+	copy(v3.Data[:32], make([]byte, 32))
+	if _, err := decodeWithoutPanic(t, v3); err == nil {
+		t.Error("decodePoolLog of a V3 pool with tick spacing 0 succeeded, want an error")
+	}
+
+	iZiSwap := poolCreationLog(t, "iZiSwap NewPool")
+	copy(iZiSwap.Data[:32], bytes.Repeat([]byte{0xff}, 32))
+	if _, err := decodeWithoutPanic(t, iZiSwap); err == nil {
+		t.Error("decodePoolLog of an iZiSwap pool with point delta 2^256-1 succeeded, want an error")
+	}
+
+	// Bools are one 0 or 1 word, whether indexed or not
+	aerodrome := poolCreationLog(t, "Aerodrome PoolCreated")
+	aerodrome.Topics[3] = common.BigToHash(big.NewInt(2))
+	if _, err := decodeWithoutPanic(t, aerodrome); err == nil {
+		t.Error("decodePoolLog of an Aerodrome pool with stable topic 2 succeeded, want an error")
+	}
+	solidly := poolCreationLog(t, "Solidly PairCreated")
+	solidly.Data[31] = 2
+	if _, err := decodeWithoutPanic(t, solidly); err == nil {
+		t.Error("decodePoolLog of a Solidly pair with stable word 2 succeeded, want an error")
+	}
+}
+
+func TestQuarantineLog(t *testing.T) {
+	fake := useFakeDB(t)
+	vLog := poolCreationLog(t, "Uniswap V3 PoolCreated")
+	vLog.Data = vLog.Data[:40]
+	_, err := decodePoolLog(vLog)
+	if err == nil {
+		t.Fatal("decodePoolLog of a truncated log succeeded")
+	}
+	quarantineLog(vLog, err)
+
+	statements := fake.executed()
+	if len(statements) != 1 || !strings.Contains(statements[0].query, "INSERT INTO quarantined_logs") {
+		t.Fatalf("quarantineLog ran %v, want one insert into quarantined_logs", statements)
+	}
+	want := []driver.Value{
+		vLog.TxHash.Hex(), int64(3), int64(1371714), vLog.BlockHash.Hex(), vLog.Address.Hex(),
+		vLog.Topics[0].Hex() + "," + vLog.Topics[1].Hex() + "," + vLog.Topics[2].Hex() + "," + vLog.Topics[3].Hex(),
+		hexutil.Encode(vLog.Data), err.Error(),
+	}
+	if !reflect.DeepEqual(statements[0].args, want) {
+		t.Errorf("quarantineLog stored %v, want %v", statements[0].args, want)
+	}
+}
+
+func TestParseEventDefinition(t *testing.T) {
+	signatures := map[string]string{
+		"event Transfer(address indexed from, address indexed to, uint256 value)":              "Transfer(address,address,uint256)",
+		"  PairCreated(address indexed token0, address indexed token1, address pair, uint256)": "PairCreated(address,address,address,uint256)",
+		"event Sync(uint112 reserve0, uint112 reserve1)":                                       "Sync(uint112,uint112)",
+		"event Paused()": "Paused()",
+	}
+	for definition, sig := range signatures {
+		event, err := parseEventDefinition(definition)
+		if err != nil || event.Sig != sig {
+			t.Errorf("parseEventDefinition(%q) = %s, %v; want %s", definition, event.Sig, err, sig)
+		}
+	}
+
+	event, err := parseEventDefinition("event Swap(address indexed sender, uint256 amount0In, address indexed to)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexed []bool
+	for _, input := range event.Inputs {
+		indexed = append(indexed, input.Indexed)
+	}
+	if want := []bool{true, false, true}; !reflect.DeepEqual(indexed, want) {
+		t.Errorf("indexed arguments of Swap = %v, want %v", indexed, want)
+	}
+
+	for _, definition := range []string{"event Transfer", "(address indexed from)", "event Bad(widget value)", "event Bad(address from,)"} {
+		if event, err := parseEventDefinition(definition); err == nil {
+			t.Errorf("parseEventDefinition(%q) = %s, want an error", definition, event.Sig)
+		}
+	}
+}
+
+func TestUnpackEvent(t *testing.T) {
+	transfer, err := parseEventDefinition("event Transfer(address indexed from, address indexed to, uint256 value)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := common.HexToAddress("0x1111111111111111111111111111111111111111"), common.HexToAddress("0x2222222222222222222222222222222222222222")
+	transferLog := func() types.Log {
+		return types.Log{
+			Topics: []common.Hash{transfer.ID, addressTopic(from), addressTopic(to)},
+			Data:   common.LeftPadBytes(big.NewInt(5).Bytes(), 32),
+		}
+	}
+
+	values, err := unpackEvent(transfer, transferLog())
+	if err != nil {
+		t.Fatalf("unpackEvent failed: %v", err)
+	}
+	if values["from"] != from || values["to"] != to || values["value"].(*big.Int).Int64() != 5 {
+		t.Errorf("unpackEvent = %v, want from %s, to %s, value 5", values, from.Hex(), to.Hex())
+	}
+
+	// An ERC-721 Transfer has the same signature but indexes the token id
+	nft := transferLog()
+	nft.Topics = append(nft.Topics, common.BigToHash(big.NewInt(5)))
+	nft.Data = nil
+	if _, err := unpackEvent(transfer, nft); err == nil {
+		t.Error("unpackEvent of an ERC-721 Transfer succeeded, want an error")
+	}
+}
+
+func TestAddressValue(t *testing.T) {
+	wallet := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	values := map[string]interface{}{"wallet": wallet, "zero": common.Address{}, "amount": big.NewInt(1)}
+
+	if address, err := addressValue(values, "wallet"); err != nil || address != wallet {
+		t.Errorf("addressValue(wallet) = %s, %v; want %s", address.Hex(), err, wallet.Hex())
+	}
+	for _, name := range []string{"zero", "amount", "missing"} {
+		if _, err := addressValue(values, name); err == nil {
+			t.Errorf("addressValue(%s) succeeded, want an error", name)
+		}
+	}
+
+	// Mints and burns carry the zero address, so anyAddressValue accepts it
+	if address, err := anyAddressValue(values, "zero"); err != nil || address != (common.Address{}) {
+		t.Errorf("anyAddressValue(zero) = %s, %v; want the zero address", address.Hex(), err)
+	}
+	for _, name := range []string{"amount", "missing"} {
+		if _, err := anyAddressValue(values, name); err == nil {
+			t.Errorf("anyAddressValue(%s) succeeded, want an error", name)
+		}
+	}
+}
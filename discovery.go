@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	candidateSamplePools  = 3                // Pools asked for their factory before a candidate is trusted
+	candidateBatchSize    = 50               // Candidates verified per round
+	candidatePollInterval = 30 * time.Second // Wait between verification rounds
+)
+
+var (
+	knownFactoriesMu sync.Mutex
+	knownFactories   map[common.Address]bool // Curated factories; nil unless discovery is enabled
+)
+
+// candidatesTable records each unknown emitter of a pool-creation event with the block it was first seen in.
+var candidatesTable = &batchTable{
+	name:      "factory_candidates",
+	columns:   []string{"chain_id", "address", "first_seen_block"},
+	conflict:  `ON CONFLICT (chain_id, address) DO UPDATE SET first_seen_block = LEAST(factory_candidates.first_seen_block, EXCLUDED.first_seen_block)`,
+	key:       []int{0, 1},
+	keepFirst: true,
+}
+
+// enableDiscovery makes the scan record pools from factories that are not in the curated list.
+func enableDiscovery(factories []Factory) {
+	known := make(map[common.Address]bool)
+	for _, factory := range factories {
+		known[common.HexToAddress(factory.InternalDeployer)] = true
+	}
+	knownFactoriesMu.Lock()
+	knownFactories = known
+	knownFactoriesMu.Unlock()
+}
+
+// watchKnownFactories reloads the curated list whenever the registry changes until ctx is cancelled,
+// so factories added with factory_admin stop being queued as candidates.
+func watchKnownFactories(ctx context.Context, factories []Factory) {
+	for {
+		changed := make(chan struct{}, 1)
+		watchFactories(ctx, false, factoriesFingerprint(factories), changed)
+		if ctx.Err() != nil {
+			return
+		}
+
+		reloaded, err := loadFactories(false)
+		if err != nil {
+			log.Printf("Failed to reload factories: %v", err)
+			continue
+		}
+		factories = reloaded
+		enableDiscovery(factories)
+	}
+}
+
+// noteFactoryCandidate queues the factory that emitted record for review if it is not known yet.
+func noteFactoryCandidate(record PoolRecord) {
+	knownFactoriesMu.Lock()
+	known := knownFactories == nil || knownFactories[record.Factory]
+	knownFactoriesMu.Unlock()
+	if known {
+		return
+	}
+	rowWriter.add(candidatesTable, chainID, record.Factory.Hex(), record.Log.BlockNumber)
+}
+
+// verifyFactory checks that the candidate behaves like a factory: the first pools it announced
+// must report it through factory(). The note explains the outcome; an error means the node
+// failed and the candidate should be tried again later.
+func verifyFactory(ctx context.Context, client rpcCaller, factory common.Address) (bool, string, error) {
+	rows, err := db.Query(`SELECT pair_address FROM pairs WHERE chain_id = $1 AND factory_address = $2 ORDER BY block_number LIMIT $3`,
+		chainID, factory.Hex(), candidateSamplePools)
+	if err != nil {
+		return false, "", err
+	}
+	var pools []common.Address
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			rows.Close()
+			return false, "", err
+		}
+		pools = append(pools, common.HexToAddress(address))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, "", err
+	}
+	if len(pools) == 0 {
+		return false, "no stored pools", nil
+	}
+
+	for _, pool := range pools {
+		if err := limiter.Wait(ctx); err != nil {
+			return false, "", err
+		}
+		res, err := ethCall(client, pool, functionSelector("factory()"))
+		if isRPCErrorKind(err, rpcReverted) || isRPCErrorKind(err, rpcMalformed) {
+			return false, fmt.Sprintf("pool %s has no factory(): %v", pool.Hex(), err), nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		if len(res) != 66 {
+			return false, fmt.Sprintf("pool %s returned %q from factory()", pool.Hex(), res), nil
+		}
+		if reported := common.HexToAddress(res[26:]); reported != factory {
+			return false, fmt.Sprintf("pool %s reports factory %s", pool.Hex(), reported.Hex()), nil
+		}
+	}
+	return true, fmt.Sprintf("%d pools report it as their factory", len(pools)), nil
+}
+
+// verifyCandidates checks unverified candidates until ctx is cancelled.
+func verifyCandidates(ctx context.Context, client rpcCaller) {
+	for {
+		rows, err := db.Query(`SELECT address FROM factory_candidates WHERE chain_id = $1 AND verified IS NULL ORDER BY first_seen_block LIMIT $2`,
+			chainID, candidateBatchSize)
+		var candidates []common.Address
+		if err != nil {
+			log.Printf("Failed to load factory candidates: %v", err)
+		} else {
+			for rows.Next() {
+				var address string
+				if err := rows.Scan(&address); err != nil {
+					log.Printf("Failed to load factory candidates: %v", err)
+					break
+				}
+				candidates = append(candidates, common.HexToAddress(address))
+			}
+			rows.Close()
+		}
+
+		for _, candidate := range candidates {
+			verified, note, err := verifyFactory(ctx, client, candidate)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to verify factory candidate %s: %v", candidate.Hex(), err)
+				continue
+			}
+			log.Printf("Factory candidate %s verified=%v: %s", candidate.Hex(), verified, note)
+			_, err = db.Exec(`UPDATE factory_candidates SET verified = $3, verify_note = $4, verified_at = now() WHERE chain_id = $1 AND address = $2`,
+				chainID, candidate.Hex(), verified, note)
+			if err != nil {
+				log.Printf("Failed to update factory candidate %s: %v", candidate.Hex(), err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(candidatePollInterval):
+		}
+	}
+}
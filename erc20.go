@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	maxTokenDecimals  = 77  // 10^78 does not fit in a uint256, so more decimals cannot be a real precision
+	maxTokenSymbolLen = 64  // Longer symbols are truncated
+	maxTokenNameLen   = 256 // Longer names are truncated
+)
+
+// functionSelector computes the first 4 bytes of the keccak256 hash
+// of the provided function signature, which represents its Method ID.
+func functionSelector(funcSignature string) string {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(funcSignature))
+	return "0x" + fmt.Sprintf("%x", hasher.Sum(nil)[:4])
+}
+
+// ethCall executes a call to the Ethereum network using the provided data.
+// Transient failures are retried; the rest are returned as an *RPCError.
+func ethCall(client rpcCaller, to common.Address, data string) (string, error) {
+	args := map[string]interface{}{
+		"to":   to.Hex(),
+		"data": data,
+	}
+
+	var res string
+	err := retryRPC(context.Background(), "eth_call", func(ctx context.Context) error {
+		return client.CallContext(ctx, &res, "eth_call", args, "latest")
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res) < 2 || res[:2] != "0x" {
+		return "", malformedResponse("eth_call", "unexpected result %q from %s", res, to.Hex())
+	}
+	return res, nil
+}
+
+// callWord calls a getter that returns a single 32-byte word.
+func callWord(client rpcCaller, tokenAddress common.Address, signature string) (*big.Int, error) {
+	res, err := ethCall(client, tokenAddress, functionSelector(signature))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 66 {
+		return nil, malformedResponse("eth_call", "%s of %s returned %d bytes", signature, tokenAddress.Hex(), (len(res)-2)/2)
+	}
+	value, ok := new(big.Int).SetString(res[2:], 16)
+	if !ok {
+		return nil, malformedResponse("eth_call", "%s of %s returned %q", signature, tokenAddress.Hex(), res)
+	}
+	return value, nil
+}
+
+// callString calls a getter that returns a string. Older tokens such as MKR return bytes32
+// instead, which is accepted as well.
+func callString(client rpcCaller, tokenAddress common.Address, signature string, maxLen int) (string, error) {
+	res, err := ethCall(client, tokenAddress, functionSelector(signature))
+	if err != nil {
+		return "", err
+	}
+	data, err := hexutil.Decode(res)
+	if err != nil {
+		return "", malformedResponse("eth_call", "%s of %s returned %q", signature, tokenAddress.Hex(), res)
+	}
+
+	var raw []byte
+	switch {
+	case len(data) == 32:
+		raw = data // bytes32, padded with zeros
+	case len(data) >= 64:
+		// ABI-encoded string: an offset, then the length and the bytes at that offset
+		offset := new(big.Int).SetBytes(data[:32])
+		if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(data)) {
+			return "", malformedResponse("eth_call", "%s of %s has an invalid string offset", signature, tokenAddress.Hex())
+		}
+		start := offset.Uint64() + 32
+		length := new(big.Int).SetBytes(data[start-32 : start])
+		if !length.IsUint64() || start+length.Uint64() > uint64(len(data)) {
+			return "", malformedResponse("eth_call", "%s of %s has an invalid string length", signature, tokenAddress.Hex())
+		}
+		raw = data[start : start+length.Uint64()]
+	default:
+		return "", malformedResponse("eth_call", "%s of %s returned %d bytes", signature, tokenAddress.Hex(), len(data))
+	}
+	return cleanTokenString(raw, maxLen), nil
+}
+
+// cleanTokenString turns raw string bytes into text that is safe to store: padding and NUL bytes
+// are removed, invalid UTF-8 and control characters are dropped, and the result is cut to maxLen runes.
+func cleanTokenString(raw []byte, maxLen int) string {
+	s := strings.ToValidUTF8(string(raw), "")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if utf8.RuneCountInString(s) > maxLen {
+		s = string([]rune(s)[:maxLen])
+	}
+	return s
+}
+
+// getName fetches the name of a token.
+func getName(client rpcCaller, tokenAddress common.Address) (string, error) {
+	return callString(client, tokenAddress, "name()", maxTokenNameLen)
+}
+
+// getSymbol fetches the symbol of a token.
+func getSymbol(client rpcCaller, tokenAddress common.Address) (string, error) {
+	return callString(client, tokenAddress, "symbol()", maxTokenSymbolLen)
+}
+
+// getDecimals fetches the decimal precision of a token.
+func getDecimals(client rpcCaller, tokenAddress common.Address) (int, error) {
+	decimals, err := callWord(client, tokenAddress, "decimals()")
+	if err != nil {
+		return 0, err
+	}
+	if !decimals.IsInt64() || decimals.Int64() > maxTokenDecimals {
+		return 0, malformedResponse("eth_call", "%s has %s decimals", tokenAddress.Hex(), decimals)
+	}
+	return int(decimals.Int64()), nil
+}
+
+// getTotalSupply fetches the total supply of a token in its smallest unit.
+func getTotalSupply(client rpcCaller, tokenAddress common.Address) (*big.Int, error) {
+	return callWord(client, tokenAddress, "totalSupply()")
+}
+
+// getBalance fetches the balance of an account for a specific token.
+func getBalance(client rpcCaller, tokenAddress, accountAddress common.Address) (*big.Int, error) {
+	data := functionSelector("balanceOf(address)") + hex.EncodeToString(common.LeftPadBytes(accountAddress.Bytes(), 32))
+	res, err := ethCall(client, tokenAddress, data)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, ok := new(big.Int).SetString(res[2:], 16)
+	if !ok {
+		return nil, malformedResponse("eth_call", "invalid balance %q", res)
+	}
+	return balance, nil
+}
+
+// getAllowance fetches how much of the owner's tokens the spender may still transfer.
+func getAllowance(client rpcCaller, tokenAddress, owner, spender common.Address) (*big.Int, error) {
+	res, err := ethCall(client, tokenAddress, allowanceData(owner, spender))
+	if err != nil {
+		return nil, err
+	}
+	return parseAllowance(tokenAddress, res)
+}
+
+// allowanceData returns the calldata of allowance(owner, spender).
+func allowanceData(owner, spender common.Address) string {
+	return functionSelector("allowance(address,address)") +
+		hex.EncodeToString(common.LeftPadBytes(owner.Bytes(), 32)) +
+		hex.EncodeToString(common.LeftPadBytes(spender.Bytes(), 32))
+}
+
+// parseAllowance decodes the result of an allowance() call to tokenAddress.
+func parseAllowance(tokenAddress common.Address, res string) (*big.Int, error) {
+	if len(res) < 2 || res[:2] != "0x" {
+		return nil, malformedResponse("eth_call", "unexpected result %q from %s", res, tokenAddress.Hex())
+	}
+	if len(res) != 66 {
+		return nil, malformedResponse("eth_call", "allowance of %s returned %d bytes", tokenAddress.Hex(), (len(res)-2)/2)
+	}
+
+	allowance, ok := new(big.Int).SetString(res[2:], 16)
+	if !ok {
+		return nil, malformedResponse("eth_call", "invalid allowance %q", res)
+	}
+	return allowance, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// fakeToken is a token contract behind eth_call. It answers each function signature with the
+// registered hex result and reverts calls to any other function.
+type fakeToken map[string]string
+
+func (f fakeToken) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	data := args[0].(map[string]interface{})["data"].(string)
+	for signature, res := range f {
+		if strings.HasPrefix(data, functionSelector(signature)) {
+			*result.(*string) = res
+			return nil
+		}
+	}
+	return codeError{3, "execution reverted"}
+}
+
+// abiWord returns n as one hex encoded 32 byte ABI word.
+func abiWord(n int64) string {
+	return hex.EncodeToString(common.LeftPadBytes(big.NewInt(n).Bytes(), 32))
+}
+
+// abiString returns s ABI-encoded as a function's only return value.
+func abiString(s string) string {
+	padded := make([]byte, (len(s)+31)/32*32)
+	copy(padded, s)
+	return "0x" + abiWord(32) + abiWord(int64(len(s))) + hex.EncodeToString(padded)
+}
+
+// bytes32 returns s right-padded to a bytes32 return value.
+func bytes32(s string) string {
+	return "0x" + hex.EncodeToString(common.RightPadBytes([]byte(s), 32))
+}
+
+func TestTokenMetadata(t *testing.T) {
+	token := common.HexToAddress("0x4200000000000000000000000000000000000006")
+
+	weth := fakeToken{
+		"name()":        abiString("Wrapped Ether"),
+		"symbol()":      abiString("WETH"),
+		"decimals()":    "0x" + abiWord(18),
+		"totalSupply()": "0x" + abiWord(1e18),
+	}
+	if name, err := getName(weth, token); err != nil || name != "Wrapped Ether" {
+		t.Errorf("getName = %q, %v; want Wrapped Ether", name, err)
+	}
+	if symbol, err := getSymbol(weth, token); err != nil || symbol != "WETH" {
+		t.Errorf("getSymbol = %q, %v; want WETH", symbol, err)
+	}
+	if decimals, err := getDecimals(weth, token); err != nil || decimals != 18 {
+		t.Errorf("getDecimals = %d, %v; want 18", decimals, err)
+	}
+	if supply, err := getTotalSupply(weth, token); err != nil || supply.Int64() != 1e18 {
+		t.Errorf("getTotalSupply = %v, %v; want 1e18", supply, err)
+	}
+
+	// MKR predates string getters and returns bytes32
+	mkr := fakeToken{"name()": bytes32("Maker"), "symbol()": bytes32("MKR")}
+	if name, err := getName(mkr, token); err != nil || name != "Maker" {
+		t.Errorf("bytes32 getName = %q, %v; want Maker", name, err)
+	}
+	if symbol, err := getSymbol(mkr, token); err != nil || symbol != "MKR" {
+		t.Errorf("bytes32 getSymbol = %q, %v; want MKR", symbol, err)
+	}
+
+	// Names longer than one word and longer than maxTokenSymbolLen
+	long := fakeToken{"name()": abiString(strings.Repeat("Long name ", 10)), "symbol()": abiString(strings.Repeat("S", 100))}
+	if name, err := getName(long, token); err != nil || name != strings.TrimSpace(strings.Repeat("Long name ", 10)) {
+		t.Errorf("getName of a 100 byte name = %q, %v", name, err)
+	}
+	if symbol, err := getSymbol(long, token); err != nil || symbol != strings.Repeat("S", maxTokenSymbolLen) {
+		t.Errorf("getSymbol of a 100 byte symbol = %q, %v; want it cut to %d", symbol, err, maxTokenSymbolLen)
+	}
+
+	// A token without the getter reverts
+	if _, err := getName(fakeToken{}, token); !isRPCErrorKind(err, rpcReverted) {
+		t.Errorf("getName without name() = %v, want a reverted RPCError", err)
+	}
+}
+
+func TestTokenMetadataMalformed(t *testing.T) {
+	token := common.HexToAddress("0x4200000000000000000000000000000000000006")
+
+	names := []string{
+		"0x" + abiWord(96) + abiWord(3),                                         // Offset past the data
+		"0x" + abiWord(32) + abiWord(40) + hex.EncodeToString(make([]byte, 32)), // Length past the data
+		"0x" + hex.EncodeToString(make([]byte, 31)),                             // Neither a word nor a string
+		"0x",
+		"0xzz",
+		"",
+	}
+	for _, res := range names {
+		if name, err := getName(fakeToken{"name()": res}, token); !isRPCErrorKind(err, rpcMalformed) {
+			t.Errorf("getName of %q = %q, %v; want a malformed response error", res, name, err)
+		}
+	}
+
+	for _, res := range []string{"0x" + abiWord(78), "0x" + abiWord(18) + abiWord(0), "0x12"} {
+		if decimals, err := getDecimals(fakeToken{"decimals()": res}, token); !isRPCErrorKind(err, rpcMalformed) {
+			t.Errorf("getDecimals of %q = %d, %v; want a malformed response error", res, decimals, err)
+		}
+	}
+}
+
+func TestCleanTokenString(t *testing.T) {
+	cleaned := map[string]string{
+		"USDC":                 "USDC",
+		"MKR\x00\x00\x00":      "MKR",
+		"  Wrapped Ether ":     "Wrapped Ether",
+		"AB\xffC":              "ABC",
+		"A\nB\tC\x1b[0m":       "ABC[0m",
+		"":                     "",
+		"\x00\x00\x00\x00\x00": "",
+	}
+	for raw, want := range cleaned {
+		if got := cleanTokenString([]byte(raw), 64); got != want {
+			t.Errorf("cleanTokenString(%q) = %q, want %q", raw, got, want)
+		}
+	}
+
+	// maxLen counts runes, so multi-byte characters are never split
+	if got := cleanTokenString([]byte("Ünïcödé"), 3); got != "Ünï" {
+		t.Errorf("cleanTokenString(Ünïcödé, 3) = %q, want Ünï", got)
+	}
+	if got := cleanTokenString([]byte("🚀MOON"), 2); got != "🚀M" {
+		t.Errorf("cleanTokenString(🚀MOON, 2) = %q, want 🚀M", got)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// eventGroup is a set of contract events indexed together, such as every kind of swap. Each group
+// has its own checkpoint, so a newly added group is backfilled without rescanning the others.
+type eventGroup struct {
+	Name    string
+	Tables  []string // Tables the group writes; rolled back after reorgs
+	Senders bool     // Rows record the sender of the log's transaction, so senders are prefetched per page
+
+	// Optional; runs in the transaction that stores the rows of a range and checkpoints the group
+	BeforeCommit func(tx *sql.Tx, fromBlock, toBlock uint64) error
+}
+
+// eventDecoder decodes one kind of log emitted by indexed contracts and queues its rows.
+type eventDecoder struct {
+	Group  *eventGroup
+	Event  abi.Event                                                 // ABI definition of the event; Event.ID is the log's first topic
+	Handle func(vLog types.Log, values map[string]interface{}) error // Queues the rows for the decoded arguments
+}
+
+var (
+	eventGroups   = make(map[string]*eventGroup)        // Registered groups by name
+	eventDecoders = make(map[common.Hash]*eventDecoder) // Registered events by topic
+)
+
+// registerEventGroup adds a group of events writing to the given tables.
+func registerEventGroup(name string, tables ...string) *eventGroup {
+	if _, exists := eventGroups[name]; exists {
+		panic("event group registered twice: " + name)
+	}
+	group := &eventGroup{Name: name, Tables: tables}
+	eventGroups[name] = group
+	return group
+}
+
+// register adds the decoder for an event of the group, given in Solidity syntax.
+func (g *eventGroup) register(definition string, handle func(vLog types.Log, values map[string]interface{}) error) {
+	event, err := parseEventDefinition(definition)
+	if err != nil {
+		panic(fmt.Sprintf("invalid event definition %q: %v", definition, err))
+	}
+	if _, exists := eventDecoders[event.ID]; exists {
+		panic("event registered twice: " + event.Sig)
+	}
+	eventDecoders[event.ID] = &eventDecoder{Group: g, Event: event, Handle: handle}
+}
+
+// eventTopics returns the topics of every event in the given groups, for use in a log filter.
+func eventTopics(groups []*eventGroup) []common.Hash {
+	wanted := make(map[*eventGroup]bool)
+	for _, group := range groups {
+		wanted[group] = true
+	}
+	var topics []common.Hash
+	for topic, decoder := range eventDecoders {
+		if wanted[decoder.Group] {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Slice(topics, func(i, j int) bool { return bytes.Compare(topics[i][:], topics[j][:]) < 0 })
+	return topics
+}
+
+// selectEventGroups returns the groups named in a comma separated list, or every group when it is empty.
+func selectEventGroups(list string) []*eventGroup {
+	var groups []*eventGroup
+	if list == "" {
+		for _, group := range eventGroups {
+			groups = append(groups, group)
+		}
+	} else {
+		for _, name := range strings.Split(list, ",") {
+			group, ok := eventGroups[strings.TrimSpace(name)]
+			if !ok {
+				log.Fatalf("Unknown event group %q", name)
+			}
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// groupScanner returns the checkpoint name of an event group indexed by a monitor: <monitor>:<group>.
+func groupScanner(monitor string, group *eventGroup) string {
+	return monitor + ":" + group.Name
+}
+
+// groupScanners returns the checkpoint names of the event groups indexed by a monitor.
+func groupScanners(monitor string, groups []*eventGroup) []string {
+	scanners := make([]string, len(groups))
+	for i, group := range groups {
+		scanners[i] = groupScanner(monitor, group)
+	}
+	return scanners
+}
+
+// handleEventLog decodes a log with the decoder registered for its first topic and queues its rows.
+func handleEventLog(vLog types.Log) error {
+	if len(vLog.Topics) == 0 {
+		return fmt.Errorf("log has no topics")
+	}
+	decoder, ok := eventDecoders[vLog.Topics[0]]
+	if !ok {
+		return fmt.Errorf("no decoder registered for topic %s", vLog.Topics[0].Hex())
+	}
+
+	values, err := unpackEvent(decoder.Event, vLog)
+	if err != nil {
+		return fmt.Errorf("%s: %v", decoder.Event.Sig, err)
+	}
+	if err := decoder.Handle(vLog, values); err != nil {
+		return fmt.Errorf("%s: %v", decoder.Event.Sig, err)
+	}
+	return nil
+}
+
+// eventSignature returns the signature of the registered event that emitted vLog.
+func eventSignature(vLog types.Log) string {
+	if decoder, ok := eventDecoders[vLog.Topics[0]]; ok {
+		return decoder.Event.Sig
+	}
+	return "unknown " + vLog.Topics[0].Hex()
+}
+
+// logTimestamp returns the time of the block that emitted vLog, or NULL if it cannot be looked up.
+// commitEventLogs prefetches the page's blocks, so this is normally answered from the cache.
+func logTimestamp(vLog types.Log) sql.NullTime {
+	t, err := blockTimestamp(vLog.BlockNumber)
+	if err != nil {
+		log.Printf("Failed to get the timestamp of block %d: %v", vLog.BlockNumber, err)
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t, Valid: true}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const factoryReloadInterval = 30 * time.Second // How often a running monitor checks the registry for changes
+
+// Factory is one entry of the factory registry in the factories table.
+type Factory struct {
+	InternalDeployer string `json:"internal_deployer"`
+	EntityID         string `json:"entity_id"`
+	DexType          string `json:"dex_type,omitempty"` // v2, v3 or solidly; empty when unknown
+	Router           string `json:"router,omitempty"`
+	InitCodeHash     string `json:"init_code_hash,omitempty"`
+
+	Fee             sql.NullInt64 `json:"-"` // Fee of every pool, in hundredths of a basis point, when the factory has one
+	DeploymentBlock sql.NullInt64 `json:"-"` // Block the factory was deployed in, where its scan starts
+	Enabled         bool          `json:"-"`
+}
+
+// readFactoriesFile reads a curated factory list in the factories.json format.
+func readFactoriesFile(path string) []Factory {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+
+	var factories []Factory
+	if err := json.Unmarshal(data, &factories); err != nil {
+		log.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+	return factories
+}
+
+// loadFactories returns the registered factories of the chain, optionally only the enabled ones.
+func loadFactories(enabledOnly bool) ([]Factory, error) {
+	query := `
+        SELECT address, entity_id, COALESCE(dex_type, ''), COALESCE(router, ''), COALESCE(init_code_hash, ''),
+               fee, deployment_block, enabled
+        FROM factories
+        WHERE chain_id = $1 AND (enabled OR NOT $2)
+        ORDER BY address
+    `
+	rows, err := db.Query(query, chainID, enabledOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var factories []Factory
+	for rows.Next() {
+		var f Factory
+		if err := rows.Scan(&f.InternalDeployer, &f.EntityID, &f.DexType, &f.Router, &f.InitCodeHash, &f.Fee, &f.DeploymentBlock, &f.Enabled); err != nil {
+			return nil, err
+		}
+		factories = append(factories, f)
+	}
+	return factories, rows.Err()
+}
+
+// saveFactory adds a factory to the registry, or updates it if it is already registered.
+// Addresses are stored checksummed, like factory_address in pairs.
+func saveFactory(f Factory) error {
+	query := `
+        INSERT INTO factories (chain_id, address, entity_id, dex_type, router, init_code_hash, fee, deployment_block, enabled)
+        VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9)
+        ON CONFLICT (chain_id, address) DO UPDATE SET
+            entity_id        = EXCLUDED.entity_id,
+            dex_type         = COALESCE(EXCLUDED.dex_type, factories.dex_type),
+            router           = COALESCE(EXCLUDED.router, factories.router),
+            init_code_hash   = COALESCE(EXCLUDED.init_code_hash, factories.init_code_hash),
+            fee              = COALESCE(EXCLUDED.fee, factories.fee),
+            deployment_block = COALESCE(EXCLUDED.deployment_block, factories.deployment_block),
+            enabled          = EXCLUDED.enabled,
+            updated_at       = now()
+    `
+	router := f.Router
+	if router != "" {
+		router = common.HexToAddress(router).Hex()
+	}
+	_, err := db.Exec(query, chainID, common.HexToAddress(f.InternalDeployer).Hex(), f.EntityID, f.DexType, router,
+		strings.ToLower(f.InitCodeHash), f.Fee, f.DeploymentBlock, f.Enabled)
+	return err
+}
+
+// setFactoryEnabled enables or disables a registered factory. It reports whether the factory exists.
+func setFactoryEnabled(address common.Address, enabled bool) (bool, error) {
+	res, err := db.Exec(`UPDATE factories SET enabled = $3, updated_at = now() WHERE chain_id = $1 AND address = $2`,
+		chainID, address.Hex(), enabled)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// importFactories registers the factories in a factories.json file that are not registered yet and
+// returns how many were added. Registered factories, including disabled ones, are left alone.
+func importFactories(path string) (int, error) {
+	registered, err := loadFactories(false)
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[common.Address]bool)
+	for _, f := range registered {
+		known[common.HexToAddress(f.InternalDeployer)] = true
+	}
+
+	added := 0
+	for _, f := range readFactoriesFile(path) {
+		if known[common.HexToAddress(f.InternalDeployer)] {
+			continue
+		}
+		f.Enabled = true
+		if err := saveFactory(f); err != nil {
+			return added, fmt.Errorf("factory %s: %v", f.InternalDeployer, err)
+		}
+		known[common.HexToAddress(f.InternalDeployer)] = true
+		added++
+	}
+	return added, nil
+}
+
+// seedFactories imports factories.json when the chain has no registered factories yet, so a
+// fresh registry starts out with the curated list.
+func seedFactories() {
+	registered, err := loadFactories(false)
+	if err != nil {
+		log.Fatalf("Failed to load factories: %v", err)
+	}
+	if len(registered) > 0 {
+		return
+	}
+	n, err := importFactories("factories.json")
+	if err != nil {
+		log.Fatalf("Failed to import factories.json: %v", err)
+	}
+	log.Printf("Factory registry was empty, imported %d factories from factories.json", n)
+}
+
+// Error messages nodes without archive state return for state older than they keep.
+var missingStateMessages = []string{
+	"missing trie node",
+	"header not found",
+	"state is not available",
+	"historical state",
+	"pruned",
+}
+
+// errNoArchiveState is returned by hasCode when the node no longer has the state of the block.
+var errNoArchiveState = errors.New("the node has no archive state for the block")
+
+// hasCode reports whether a contract is deployed at address as of block, a block tag or hex number.
+// It waits on the limiter before every attempt. A node that has pruned the block's state fails
+// the same way every time, so that is not retried and errNoArchiveState is returned instead.
+func hasCode(client rpcCaller, address common.Address, block string) (bool, error) {
+	var code hexutil.Bytes
+	err := retryRPC(context.Background(), "eth_getCode", func(ctx context.Context) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return noRetry(err)
+		}
+		err := client.CallContext(ctx, &code, "eth_getCode", address, block)
+		if err != nil && containsAny(strings.ToLower(err.Error()), missingStateMessages) {
+			return noRetry(fmt.Errorf("%w %s: %v", errNoArchiveState, block, err))
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(code) > 0, nil
+}
+
+// findDeploymentBlock binary searches for the first block at which address has code, assuming
+// the contract exists at head. Historical code needs a node with archive state; without it the
+// search stops at the first pruned block with an errNoArchiveState error.
+func findDeploymentBlock(client rpcCaller, address common.Address, head uint64) (uint64, error) {
+	codeAt := func(block uint64) (bool, error) {
+		return hasCode(client, address, hexutil.EncodeUint64(block))
+	}
+
+	ok, err := codeAt(head)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("no contract at %s as of block %d", address.Hex(), head)
+	}
+
+	// The code exists at hi and not before lo
+	lo, hi := uint64(0), head
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		ok, err := codeAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return hi, nil
+}
+
+// detectDeploymentBlocks finds and stores the deployment block of every factory that has none yet
+// and returns the factories with their blocks filled in. A factory whose block cannot be found is
+// returned unchanged and scanned from the default start block.
+func detectDeploymentBlocks(client rpcCaller, factories []Factory, head uint64) []Factory {
+	for i, f := range factories {
+		if f.DeploymentBlock.Valid {
+			continue
+		}
+		address := common.HexToAddress(f.InternalDeployer)
+		block, err := findDeploymentBlock(client, address, head)
+		if err != nil {
+			log.Printf("Failed to find the deployment block of %s: %v", f.InternalDeployer, err)
+			continue
+		}
+		if err := setDeploymentBlock(address, block); err != nil {
+			log.Printf("Failed to store the deployment block of %s: %v", f.InternalDeployer, err)
+			continue
+		}
+		log.Printf("Factory %s (%s) was deployed in block %d", f.InternalDeployer, f.EntityID, block)
+		factories[i].DeploymentBlock = sql.NullInt64{Int64: int64(block), Valid: true}
+	}
+	return factories
+}
+
+// setDeploymentBlock stores the block a registered factory was deployed in.
+func setDeploymentBlock(address common.Address, block uint64) error {
+	_, err := db.Exec(`UPDATE factories SET deployment_block = $3, updated_at = now() WHERE chain_id = $1 AND address = $2`,
+		chainID, address.Hex(), block)
+	return err
+}
+
+// factoriesFingerprint summarizes what a monitor scans for, so registry changes can be noticed.
+func factoriesFingerprint(factories []Factory) string {
+	parts := make([]string, len(factories))
+	for i, f := range factories {
+		parts[i] = fmt.Sprintf("%s/%s/%d", strings.ToLower(f.InternalDeployer), f.EntityID, f.DeploymentBlock.Int64)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// watchFactories checks the factories, or only the enabled ones, every factoryReloadInterval and
+// signals changed once they no longer match fingerprint. It returns after signalling or when ctx is
+// cancelled.
+func watchFactories(ctx context.Context, enabledOnly bool, fingerprint string, changed chan<- struct{}) {
+	ticker := time.NewTicker(factoryReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		factories, err := loadFactories(enabledOnly)
+		if err != nil {
+			log.Printf("Failed to reload factories: %v", err)
+			continue
+		}
+		if factoriesFingerprint(factories) != fingerprint {
+			log.Println("Factory registry changed, reloading...")
+			changed <- struct{}{}
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// fakeCodeNode answers eth_getCode for a contract deployed in block deployed. Without archive
+// state it only keeps the state from block prunedBefore on.
+type fakeCodeNode struct {
+	deployed     uint64
+	prunedBefore uint64
+	calls        int
+}
+
+func (n *fakeCodeNode) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	n.calls++
+	block, err := hexutil.DecodeUint64(args[1].(string))
+	if err != nil {
+		return err
+	}
+	if block < n.prunedBefore {
+		return codeError{-32000, "missing trie node 1a2b3c (path ) state 0x1a2b3c is not available"}
+	}
+	if block >= n.deployed {
+		*result.(*hexutil.Bytes) = hexutil.Bytes{0x60, 0x80}
+	} else {
+		*result.(*hexutil.Bytes) = hexutil.Bytes{}
+	}
+	return nil
+}
+
+func TestFindDeploymentBlock(t *testing.T) {
+	factory := common.HexToAddress("0x8909Dc15e40173Ff4699343b6eB8132c65e18eC6")
+	const head = 20000000
+
+	for _, deployed := range []uint64{0, 1, 1371714, head - 1, head} {
+		node := &fakeCodeNode{deployed: deployed}
+		block, err := findDeploymentBlock(node, factory, head)
+		if err != nil || block != deployed {
+			t.Errorf("findDeploymentBlock of a contract deployed in block %d = %d, %v", deployed, block, err)
+		}
+		if node.calls > 26 {
+			t.Errorf("finding block %d took %d calls, want a binary search", deployed, node.calls)
+		}
+	}
+
+	if _, err := findDeploymentBlock(&fakeCodeNode{deployed: head + 1}, factory, head); err == nil {
+		t.Error("findDeploymentBlock of a contract deployed after the head succeeded")
+	}
+}
+
+func TestFindDeploymentBlockWithoutArchive(t *testing.T) {
+	factory := common.HexToAddress("0x8909Dc15e40173Ff4699343b6eB8132c65e18eC6")
+
+	// The first probe, halfway back, hits pruned state; it is not retried
+	node := &fakeCodeNode{deployed: 1371714, prunedBefore: 19990000}
+	_, err := findDeploymentBlock(node, factory, 20000000)
+	if !errors.Is(err, errNoArchiveState) || !strings.Contains(err.Error(), "missing trie node") {
+		t.Errorf("findDeploymentBlock on a pruned node = %v, want errNoArchiveState", err)
+	}
+	if node.calls != 2 {
+		t.Errorf("findDeploymentBlock on a pruned node made %d calls, want 2", node.calls)
+	}
+}
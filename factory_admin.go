@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"golang.org/x/time/rate"
+)
+
+var limiter = rate.NewLimiter(rate.Limit(24), 1) // 24 requests per second
+
+const (
+	infuraURL = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
+)
+
+const adminUsage = `Usage: factory_admin [-rpc urls] <command>
+
+Commands:
+  list [-all]                       list enabled factories, or every factory with -all
+  add -address A -entity E [...]    register or update a factory (run "add -h" for its options)
+  disable ADDRESS                   stop scanning a factory
+  enable ADDRESS                    resume scanning a factory
+  validate [ADDRESS...]             check enabled factories, or the given ones, against the chain
+  detect [ADDRESS...]               find missing deployment blocks, or redo them for the given factories
+  import [FILE]                     register every factory in FILE (default factories.json)`
+
+// parseAddress parses a command-line address, rejecting anything that is not 20 hex bytes.
+func parseAddress(value string) common.Address {
+	if !common.IsHexAddress(value) {
+		log.Fatalf("Invalid address %q", value)
+	}
+	return common.HexToAddress(value)
+}
+
+// selectFactories returns the factories named by addresses, or all of them when none are named.
+// Naming an unregistered factory is an error.
+func selectFactories(factories []Factory, addresses []string) []Factory {
+	if len(addresses) == 0 {
+		return factories
+	}
+	wanted := make(map[common.Address]bool)
+	for _, arg := range addresses {
+		wanted[parseAddress(arg)] = true
+	}
+
+	var selected []Factory
+	for _, f := range factories {
+		address := common.HexToAddress(f.InternalDeployer)
+		if wanted[address] {
+			selected = append(selected, f)
+			delete(wanted, address)
+		}
+	}
+	for address := range wanted {
+		log.Fatalf("Factory %s is not registered", address.Hex())
+	}
+	return selected
+}
+
+// listFactories prints the registry with the number of pools stored for each factory.
+func listFactories(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	all := fs.Bool("all", false, "include disabled factories")
+	fs.Parse(args)
+
+	factories, err := loadFactories(!*all)
+	if err != nil {
+		log.Fatalf("Failed to load factories: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tENTITY\tTYPE\tFEE\tDEPLOYED\tENABLED\tPOOLS")
+	for _, f := range factories {
+		var pools int
+		err := db.QueryRow(`SELECT count(*) FROM pairs WHERE chain_id = $1 AND factory_address = $2`, chainID, f.InternalDeployer).Scan(&pools)
+		if err != nil {
+			log.Fatalf("Failed to count pools of %s: %v", f.InternalDeployer, err)
+		}
+		fee, deployed := "-", "-"
+		if f.Fee.Valid {
+			fee = fmt.Sprint(f.Fee.Int64)
+		}
+		if f.DeploymentBlock.Valid {
+			deployed = fmt.Sprint(f.DeploymentBlock.Int64)
+		}
+		dexType := f.DexType
+		if dexType == "" {
+			dexType = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%d\n", f.InternalDeployer, f.EntityID, dexType, fee, deployed, f.Enabled, pools)
+	}
+	w.Flush()
+}
+
+// addFactory registers a factory, or updates the given fields of a registered one, and enables it.
+func addFactory(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	address := fs.String("address", "", "factory address (required)")
+	entity := fs.String("entity", "", "entity id of the DEX, such as uniswap or aerodrome (required)")
+	dexType := fs.String("type", "", "pools the factory creates: v2, v3 or solidly")
+	router := fs.String("router", "", "router address")
+	initCodeHash := fs.String("init-code-hash", "", "init code hash of the factory's pools")
+	fee := fs.Int64("fee", -1, "fee of every pool in hundredths of a basis point, for factories with a single fee")
+	deploymentBlock := fs.Int64("deployment-block", -1, "block the factory was deployed in")
+	fs.Parse(args)
+
+	if *address == "" || *entity == "" {
+		log.Fatalf("add needs -address and -entity")
+	}
+	switch *dexType {
+	case "", "v2", "v3", "solidly":
+	default:
+		log.Fatalf("Invalid DEX type %q; use v2, v3 or solidly", *dexType)
+	}
+	if *router != "" {
+		parseAddress(*router)
+	}
+	if *initCodeHash != "" {
+		if hash, err := hexutil.Decode(*initCodeHash); err != nil || len(hash) != 32 {
+			log.Fatalf("Invalid init code hash %q", *initCodeHash)
+		}
+	}
+
+	f := Factory{
+		InternalDeployer: parseAddress(*address).Hex(),
+		EntityID:         *entity,
+		DexType:          *dexType,
+		Router:           *router,
+		InitCodeHash:     *initCodeHash,
+		Fee:              sql.NullInt64{Int64: *fee, Valid: *fee >= 0},
+		DeploymentBlock:  sql.NullInt64{Int64: *deploymentBlock, Valid: *deploymentBlock >= 0},
+		Enabled:          true,
+	}
+	if err := saveFactory(f); err != nil {
+		log.Fatalf("Failed to save factory: %v", err)
+	}
+
+	// A registered factory is no longer waiting for review
+	_, err := db.Exec(`UPDATE factory_candidates SET status = 'approved' WHERE chain_id = $1 AND address = $2`, chainID, f.InternalDeployer)
+	if err != nil {
+		log.Fatalf("Failed to update the review queue: %v", err)
+	}
+	log.Printf("Saved factory %s (%s)", f.InternalDeployer, f.EntityID)
+}
+
+// enableFactory enables or disables one factory.
+func enableFactory(args []string, enabled bool) {
+	if len(args) != 1 {
+		log.Fatalf("Expected exactly one factory address")
+	}
+	address := parseAddress(args[0])
+	found, err := setFactoryEnabled(address, enabled)
+	if err != nil {
+		log.Fatalf("Failed to update factory: %v", err)
+	}
+	if !found {
+		log.Fatalf("Factory %s is not registered", address.Hex())
+	}
+	log.Printf("Factory %s enabled=%v", address.Hex(), enabled)
+}
+
+// validateFactory checks a registered factory against the chain and returns the problems found.
+func validateFactory(client *RPCPool, f Factory) []string {
+	var problems []string
+	address := common.HexToAddress(f.InternalDeployer)
+
+	if ok, err := hasCode(client, address, "latest"); err != nil {
+		problems = append(problems, fmt.Sprintf("failed to read code: %v", err))
+	} else if !ok {
+		problems = append(problems, "no contract at the address")
+	}
+
+	if f.Router != "" {
+		if ok, err := hasCode(client, common.HexToAddress(f.Router), "latest"); err != nil {
+			problems = append(problems, fmt.Sprintf("failed to read router code: %v", err))
+		} else if !ok {
+			problems = append(problems, "no contract at the router address")
+		}
+	}
+
+	// The factory must exist at its deployment block and not before
+	if f.DeploymentBlock.Valid {
+		block := uint64(f.DeploymentBlock.Int64)
+		if ok, err := hasCode(client, address, hexutil.EncodeUint64(block)); err != nil {
+			problems = append(problems, fmt.Sprintf("failed to read code at the deployment block: %v", err))
+		} else if !ok {
+			problems = append(problems, fmt.Sprintf("no contract yet at deployment block %d", block))
+		}
+		if block > 0 {
+			if ok, err := hasCode(client, address, hexutil.EncodeUint64(block-1)); err == nil && ok {
+				problems = append(problems, fmt.Sprintf("contract already deployed before block %d", block))
+			}
+		}
+	}
+
+	// Stored pools must match the DEX type
+	if f.DexType != "" {
+		var mismatched int
+		query := `
+            SELECT count(*) FROM pairs
+            WHERE chain_id = $1 AND factory_address = $2
+              AND NOT (pool_type = $3 OR ($3 = 'solidly' AND pool_type LIKE 'solidly-%'))
+        `
+		if err := db.QueryRow(query, chainID, f.InternalDeployer, f.DexType).Scan(&mismatched); err != nil {
+			problems = append(problems, fmt.Sprintf("failed to check pool types: %v", err))
+		} else if mismatched > 0 {
+			problems = append(problems, fmt.Sprintf("%d stored pools are not %s pools", mismatched, f.DexType))
+		}
+	}
+
+	// Its pools must report it as their factory
+	verified, note, err := verifyFactory(context.Background(), client, address)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to check pools: %v", err))
+	} else if !verified && note != "no stored pools" {
+		problems = append(problems, note)
+	}
+	return problems
+}
+
+// validateFactories validates the given factories, or every enabled one, and exits with status 1
+// if any of them has problems.
+func validateFactories(client *RPCPool, args []string) {
+	all, err := loadFactories(len(args) == 0)
+	if err != nil {
+		log.Fatalf("Failed to load factories: %v", err)
+	}
+	factories := selectFactories(all, args)
+
+	failed := 0
+	for _, f := range factories {
+		problems := validateFactory(client, f)
+		if len(problems) == 0 {
+			fmt.Printf("OK    %s (%s)\n", f.InternalDeployer, f.EntityID)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s (%s): %s\n", f.InternalDeployer, f.EntityID, strings.Join(problems, "; "))
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d factories failed validation\n", failed, len(factories))
+		os.Exit(1)
+	}
+}
+
+// detectFactories finds the deployment block of every factory that has none, or of the given
+// factories even if they have one.
+func detectFactories(client *RPCPool, args []string) {
+	factories, err := loadFactories(false)
+	if err != nil {
+		log.Fatalf("Failed to load factories: %v", err)
+	}
+	if len(args) > 0 {
+		factories = selectFactories(factories, args)
+		for i := range factories {
+			factories[i].DeploymentBlock = sql.NullInt64{} // Search again
+		}
+	}
+
+	head, err := client.BlockNumber(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to fetch the chain head: %v", err)
+	}
+	detectDeploymentBlocks(client, factories, head)
+}
+
+func main() {
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	flag.Usage = func() { fmt.Fprintln(os.Stderr, adminUsage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	initDB()
+	migrateUp()
+
+	client, err := dialRPCPool(rpcURLs(*rpcList))
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+	defer client.Close()
+	initPairs(client) // The registry is per chain
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "list":
+		listFactories(args)
+	case "add":
+		addFactory(args)
+	case "disable":
+		enableFactory(args, false)
+	case "enable":
+		enableFactory(args, true)
+	case "validate":
+		validateFactories(client, args)
+	case "detect":
+		detectFactories(client, args)
+	case "import":
+		path := "factories.json"
+		if len(args) > 0 {
+			path = args[0]
+		}
+		n, err := importFactories(path)
+		if err != nil {
+			log.Fatalf("Failed to import %s: %v", path, err)
+		}
+		log.Printf("Imported %d factories from %s", n, path)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -2,171 +2,242 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"flag"
 	"log"
-	"math/big"
-	"os"
 	"strings"
 
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/rpc"
-	_ "github.com/lib/pq"
+	"github.com/ethereum/go-ethereum/core/types"
 	"golang.org/x/time/rate"
 )
 
-var limiter = rate.NewLimiter(rate.Limit(24), 1) // 25 requests per second
-
-type Config struct {
-	InfuraURL string `json:"infuraURL"`
-	Pass      string `json:"db_password"`
-}
+var limiter = rate.NewLimiter(rate.Limit(24), 1) // 24 requests per second
 
 const (
-	infuraURL  = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
-	factoryAbi = `[{"anonymous":false,"inputs":[{"indexed":true,"internalType":"address","name":"token0","type":"address"},{"indexed":true,"internalType":"address","name":"token1","type":"address"},{"indexed":false,"internalType":"address","name":"pair","type":"address"}],"name":"PairCreated","type":"event"}]`
+	infuraURL = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
 )
 
-type Factory struct {
-	InternalDeployer string `json:"internal_deployer"`
-	EntityID         string `json:"entity_id"`
+// factoryScanner returns the checkpoint name used for a single factory.
+func factoryScanner(factory Factory) string {
+	return "factory_monitor:" + strings.ToLower(factory.InternalDeployer)
 }
 
-type PairCreatedEvent struct {
-	Stable bool // new field for the 'stable' boolean
-	Pair   common.Address
-	From   common.Address // New field to capture the deployer address
+// factoryQuery returns the log filter for the pool-creation events of the given factories.
+func factoryQuery(addresses ...common.Address) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		Addresses: addresses,
+		Topics:    [][]common.Hash{poolCreationTopics()},
+	}
 }
 
-var db *sql.DB
+// scanFactoryRange indexes every pool the factory created in [fromBlock, toBlock] and checkpoints the
+// range in the same transaction.
+func scanFactoryRange(client *RPCPool, pager *logPager, factory Factory, fromBlock, toBlock uint64) {
+	log.Printf("Processing factory %s, blocks %d to %d", factory.InternalDeployer, fromBlock, toBlock)
 
-func initDB() {
-	// Set up the database connection.
+	query := factoryQuery(common.HexToAddress(factory.InternalDeployer))
+	logs, err := pager.filterLogs(context.Background(), client, query, fromBlock, toBlock)
+	if err != nil {
+		log.Fatalf("Failed to filter logs: %v", err)
+	}
+
+	log.Printf("Found %d logs for factory %s", len(logs), factory.InternalDeployer)
 
-	connStr := "user=emmett dbname=cryptoarch sslmode=disable password=password"
-	var err error
-	db, err = sql.Open("postgres", connStr)
+	prefetchBlockTimes(logs)
+	prefetchSenders(logs)
+	for _, vLog := range logs {
+		handleFactoryLog(factory, vLog)
+	}
+
+	// The pairs and the checkpoint are written together
+	rowWriter.flush(toBlock, factoryScanner(factory))
+}
+
+// handleFactoryLog decodes a single pool-creation log emitted by factory and stores the pool.
+func handleFactoryLog(factory Factory, vLog types.Log) {
+	record, err := decodePoolLog(vLog)
 	if err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+		quarantineLog(vLog, err)
+		return
 	}
+
+	log.Printf("New Pool Created: %s (%s), Tokens: %s, %s, Factory: %s (%s), Event: %s", record.Pool.Hex(), record.Type, record.Token0.Hex(), record.Token1.Hex(), record.Factory.Hex(), factory.EntityID, record.Event)
+	insertPool(record)
 }
 
-func insertPair(pairAddress, token0Address, token1Address, deployerAddress, factoryAddress string) {
-	query := `
-        INSERT INTO pairs (pair_address, token0_address, token1_address, deployer_address, factory_address)
-        VALUES ($1, $2, $3, $4, $5)
-    `
-	_, err := db.Exec(query, pairAddress, token0Address, token1Address, deployerAddress, factoryAddress)
+// loadEnabledFactories returns the enabled factories, first finding the deployment block of any
+// factory that has none, so its scan can skip the history before it existed.
+func loadEnabledFactories(client *RPCPool) []Factory {
+	factories, err := loadFactories(true)
+	if err != nil {
+		log.Fatalf("Failed to load factories: %v", err)
+	}
+	head, err := client.BlockNumber(context.Background())
 	if err != nil {
-		log.Printf("Failed to insert pair: %v", err)
+		log.Fatalf("Failed to fetch the chain head: %v", err)
 	}
+	return detectDeploymentBlocks(client, factories, head)
+}
+
+// followFactories follows the chain head for the given factories until the registry changes,
+// and returns the next block still to be indexed.
+func followFactories(ctx context.Context, client *RPCPool, guard *reorgGuard, factories []Factory, pagers map[string]*logPager,
+	wsURL string, head func(ctx context.Context) (uint64, error), changed <-chan struct{}, nextBlock uint64) uint64 {
+	// Subscriptions deliver logs from every factory at once, so look them up by address
+	byAddress := make(map[common.Address]Factory)
+	addresses := make([]common.Address, len(factories))
+	scanners := make([]string, len(factories))
+	for i, factory := range factories {
+		addresses[i] = common.HexToAddress(factory.InternalDeployer)
+		byAddress[addresses[i]] = factory
+		scanners[i] = factoryScanner(factory)
+	}
+
+	checkpoint := func(lastBlock uint64) {
+		rowWriter.flush(lastBlock, scanners...)
+	}
+	guard.checkpoint = checkpoint
+
+	f := &follower{
+		wsURL: wsURL,
+		query: factoryQuery(addresses...),
+		guard: guard,
+		head:  head,
+		scanRange: func(fromBlock, toBlock uint64) {
+			for _, factory := range factories {
+				scanFactoryRange(client, pagers[factoryScanner(factory)], factory, fromBlock, toBlock)
+			}
+		},
+		handleLog: func(vLog types.Log) {
+			// Subscribed logs are written at once rather than waiting for the next block's checkpoint
+			handleFactoryLog(byAddress[vLog.Address], vLog)
+			rowWriter.flush(0)
+		},
+		checkpoint: checkpoint,
+		restart:    changed,
+	}
+	return f.run(ctx, nextBlock)
 }
 
 func main() {
+	rewind := flag.Int64("rewind", -1, "rewind every factory checkpoint so the scan restarts at this block")
+	follow := flag.Bool("follow", false, "keep indexing new blocks after the historical range is done")
+	wsURL := flag.String("ws", "", "websocket endpoint used to subscribe to new logs in follow mode")
+	confirmations := flag.Uint64("confirmations", 0, "only index blocks this many blocks behind the head")
+	headTag := flag.String("head", "latest", "block tag to treat as the head: latest, safe or finalized")
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	flag.Parse()
+
 	initDB() // Initialize the database
 
+	// "migrate up|down|status" only manages the schema; otherwise bring it up to date and scan
+	if flag.Arg(0) == "migrate" {
+		runMigrateCommand(flag.Args()[1:])
+		return
+	}
+	migrateUp()
+
 	log.Println("Starting script...")
 
-	rpcClient, err := rpc.Dial(infuraURL)
+	client, err := dialRPCPool(rpcURLs(*rpcList))
 	if err != nil {
 		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
 	}
+	defer client.Close()
 
-	client := ethclient.NewClient(rpcClient)
+	initPairs(client)
+	go enrichTokens(context.Background(), client)
 
-	eventABI, err := abi.JSON(strings.NewReader(factoryAbi))
-	if err != nil {
-		log.Fatalf("Failed to parse ABI: %v", err)
-	}
+	seedFactories()
+	log.Println("Loading factories from the registry...")
+	factories := loadEnabledFactories(client)
 
-	log.Println("Loading factories from factories.json...")
-	data, err := os.ReadFile("factories.json")
-	if err != nil {
-		log.Fatalf("Failed to read factories.json: %v", err)
-	}
+	startBlock := uint64(1500000) // Used for factories whose deployment block could not be found
+	endBlock := uint64(4000000)   // Replace with the actual ending block number
 
-	var factories []Factory
-	err = json.Unmarshal(data, &factories)
-	if err != nil {
-		log.Fatalf("Failed to unmarshal JSON: %v", err)
+	// In follow mode the historical range runs up to the current confirmed head
+	head := func(ctx context.Context) (uint64, error) {
+		return confirmedHead(ctx, client, *headTag, *confirmations)
 	}
-
-	startBlock := big.NewInt(1500000) // Replace with the actual starting block number
-	endBlock := big.NewInt(4000000)   // Replace with the actual ending block number or use nil for the latest block
-
-	pageSize := big.NewInt(10000) // Set page size to 10,000 blocks
-
-	for currentPage := new(big.Int).Set(startBlock); currentPage.Cmp(endBlock) < 0; currentPage.Add(currentPage, pageSize) {
-		// Calculate fromBlock and toBlock for the current page
-		fromBlock := new(big.Int).Set(currentPage)
-		toBlock := new(big.Int).Add(new(big.Int).Set(currentPage), pageSize)
-		if toBlock.Cmp(endBlock) > 0 {
-			toBlock = endBlock
+	if *follow {
+		if endBlock, err = head(context.Background()); err != nil {
+			log.Fatalf("Failed to fetch the chain head: %v", err)
 		}
+	}
 
-		log.Printf("Processing blocks %s to %s...\n", fromBlock.String(), toBlock.String())
-
+	// Each factory is backfilled on its own, from its deployment block and continuing
+	// after its checkpoint. Pages start at 10,000 blocks and adapt to how busy the factory is.
+	pagers := make(map[string]*logPager)
+	backfill := func(factories []Factory, endBlock uint64) {
 		for _, factory := range factories {
-			log.Printf("Processing factory: %s", factory.InternalDeployer)
-
-			factoryAddress := common.HexToAddress(factory.InternalDeployer)
-
-			query := ethereum.FilterQuery{
-				FromBlock: fromBlock,
-				ToBlock:   toBlock,
-				Addresses: []common.Address{factoryAddress},
+			scanner := factoryScanner(factory)
+			pager, ok := pagers[scanner]
+			if !ok {
+				pager = newLogPager()
+				pagers[scanner] = pager
 			}
 
-			log.Printf("Filtering logs for block range %s to %s", fromBlock.String(), toBlock.String())
-
-			// Wait for permission from the rate limiter
-			if err := limiter.Wait(context.TODO()); err != nil {
-				log.Fatalf("Rate limiter error: %v", err)
+			firstBlock := startBlock
+			if factory.DeploymentBlock.Valid {
+				firstBlock = uint64(factory.DeploymentBlock.Int64)
 			}
-
-			logs, err := client.FilterLogs(context.Background(), query)
-			if err != nil {
-				log.Fatalf("Failed to filter logs: %v", err)
+			for fromBlock := resumeBlock(scanner, firstBlock); fromBlock <= endBlock; {
+				toBlock := pager.pageEnd(fromBlock, endBlock)
+				scanFactoryRange(client, pager, factory, fromBlock, toBlock)
+				fromBlock = toBlock + 1
 			}
+		}
+	}
 
-			log.Printf("Found %d logs for factory %s", len(logs), factory.InternalDeployer)
-
-			// ... rest of the processing code ...
-			for _, vLog := range logs {
-				// Check that there are enough topics.
-				deployerAddress := vLog.Address            // This captures the deployer address from the log
-				factoryAddress := factory.InternalDeployer // This captures the factory address from the current factory
-
-				if len(vLog.Topics) < 3 {
-					log.Println("Unexpected number of topics, skipping log entry.")
-					continue
-				}
+	if *rewind >= 0 {
+		for _, factory := range factories {
+			if err := rewindCheckpoint(factoryScanner(factory), uint64(*rewind)); err != nil {
+				log.Fatalf("Failed to rewind: %v", err)
+			}
+		}
+	}
+	backfill(factories, endBlock)
 
-				if len(vLog.Data) == 0 {
-					log.Printf("Empty data field in log entry: %+v\n", vLog)
-					continue // Skip this log entry
-				}
+	if *follow {
+		log.Println("Historical range done, following the chain head...")
 
-				// The first topic is the event signature, the following topics are the indexed parameters.
-				// Trim leading zeros to get the correct Ethereum address.
-				token0 := common.BytesToAddress(vLog.Topics[1].Bytes()[12:])
-				token1 := common.BytesToAddress(vLog.Topics[2].Bytes()[12:])
+		ctx := context.Background()
+		guard := &reorgGuard{scanner: "factory_monitor", client: client, tables: []string{"pairs"}}
 
-				// Now, unpack the non-indexed parameters from the data field.
-				var event PairCreatedEvent
-				err := eventABI.UnpackIntoInterface(&event, "PairCreated", vLog.Data)
-				if err != nil {
-					log.Fatalf("Failed to unpack event data: %v", err)
-				}
+		// Continue after the factory checkpoints, which an earlier run that followed further than
+		// this run's head may have left past endBlock; the earliest one decides, so no factory skips blocks
+		nextBlock := endBlock + 1
+		for i, factory := range factories {
+			if resume := resumeBlock(factoryScanner(factory), endBlock+1); i == 0 || resume < nextBlock {
+				nextBlock = resume
+			}
+		}
+		guard.recordIndexed(ctx, nextBlock-1)
 
-				log.Printf("New Pair Created: %s, Tokens: %s, %s\n, Deployer: %s, Factory Address: %s", event.Pair.Hex(), token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
+		// Subscriptions deliver logs at the tip, so they are only used without confirmations
+		if *confirmations > 0 || *headTag != "latest" {
+			*wsURL = ""
+		}
 
-				// Update the call to insertPair to include the deployer and factory address
-				insertPair(event.Pair.Hex(), token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
+		// Follow the enabled factories until the registry changes, then reload it. Newly enabled
+		// factories are backfilled up to the followed block before following resumes.
+		for {
+			changed := make(chan struct{}, 1)
+			watchCtx, stopWatching := context.WithCancel(ctx)
+			go watchFactories(watchCtx, true, factoriesFingerprint(factories), changed)
+
+			if len(factories) == 0 {
+				// An empty address list would match every emitter, so wait for a factory instead
+				log.Println("No enabled factories, waiting for the registry to change...")
+				<-changed
+			} else {
+				nextBlock = followFactories(ctx, client, guard, factories, pagers, *wsURL, head, changed, nextBlock)
 			}
+			stopWatching()
+
+			factories = loadEnabledFactories(client)
+			backfill(factories, nextBlock-1)
 		}
 	}
 
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeDB stands in for Postgres behind the global db. It records every statement and lets the
+// test answer them, so the code that reads and writes the database runs unchanged.
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []fakeStatement
+
+	// exec answers statements run with Exec and returns the number of rows they affected;
+	// without it they affect no rows.
+	exec func(query string, args []driver.Value) (int64, error)
+	// query answers statements run with Query or QueryRow; without it they return no rows.
+	query func(query string, args []driver.Value) (columns []string, rows [][]driver.Value, err error)
+}
+
+// fakeStatement is a statement run against a fakeDB. Transactions show up as BEGIN, COMMIT and ROLLBACK.
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// useFakeDB points db at a new fakeDB until the test ends.
+func useFakeDB(t *testing.T) *fakeDB {
+	fake := &fakeDB{}
+	fakeDBsMu.Lock()
+	name := fmt.Sprintf("fake-%d", len(fakeDBs))
+	fakeDBs[name] = fake
+	fakeDBsMu.Unlock()
+
+	conn, err := sql.Open("fakedb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = conn
+	t.Cleanup(func() {
+		db = previous
+		conn.Close()
+	})
+	return fake
+}
+
+// executed returns the statements run so far.
+func (f *fakeDB) executed() []fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeStatement(nil), f.statements...)
+}
+
+func (f *fakeDB) record(query string, args []driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = append(f.statements, fakeStatement{query: query, args: args})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	fake, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake database %s", name)
+	}
+	return fakeConn{fake}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN", nil)
+	return fakeTx{c.db}, nil
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.record("COMMIT", nil)
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.record("ROLLBACK", nil)
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.record(s.query, args)
+	if s.db.exec == nil {
+		return driver.RowsAffected(0), nil
+	}
+	affected, err := s.db.exec(s.query, args)
+	return driver.RowsAffected(affected), err
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.record(s.query, args)
+	if s.db.query == nil {
+		return &fakeRows{}, nil
+	}
+	columns, rows, err := s.db.query(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	if len(r.rows[0]) != len(dest) {
+		return errors.New("row does not match the columns")
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
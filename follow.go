@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	followPollInterval = 2 * time.Second // Base produces a block every 2 seconds
+	followPageSize     = 10000           // Largest range indexed in one call while catching up
+)
+
+// follower keeps a monitor indexing new blocks once its historical range is done.
+type follower struct {
+	wsURL string               // Websocket endpoint for eth_subscribe; polling is used when empty or failing
+	query ethereum.FilterQuery // Topic/address filter; the block range is ignored
+	guard *reorgGuard          // Detects reorgs and rolls back orphaned pairs
+
+	head       func(ctx context.Context) (uint64, error) // Newest block that is safe to index
+	scanRange  func(fromBlock, toBlock uint64)           // Indexes [fromBlock, toBlock] and checkpoints it
+	handleLog  func(vLog types.Log)                      // Indexes a single log delivered by a subscription
+	checkpoint func(lastBlock uint64)                    // Records every block up to lastBlock as processed
+
+	restart <-chan struct{} // Signalled when the query must be rebuilt; nil if it never changes
+}
+
+var errFollowRestart = errors.New("follower restart requested")
+
+// run follows the chain head starting at nextBlock until ctx is cancelled or a restart is
+// requested, and returns the next block still to be indexed.
+func (f *follower) run(ctx context.Context, nextBlock uint64) uint64 {
+	if f.wsURL != "" {
+		var err error
+		nextBlock, err = f.subscribe(ctx, nextBlock)
+		if ctx.Err() != nil || err == errFollowRestart {
+			return nextBlock
+		}
+		log.Printf("Log subscription stopped (%v), falling back to polling from block %d", err, nextBlock)
+	}
+	return f.poll(ctx, nextBlock)
+}
+
+// catchUp indexes [nextBlock, head] in pages and returns the next block to index. Before each
+// page it checks that the blocks already indexed are still canonical.
+func (f *follower) catchUp(ctx context.Context, nextBlock, head uint64) uint64 {
+	for nextBlock <= head {
+		var err error
+		if nextBlock, err = f.guard.verify(ctx, nextBlock); err != nil {
+			log.Fatalf("Failed to verify indexed blocks: %v", err)
+		}
+
+		toBlock := nextBlock + followPageSize - 1
+		if toBlock > head {
+			toBlock = head
+		}
+		f.scanRange(nextBlock, toBlock)
+
+		if err := f.guard.record(ctx, nextBlock, toBlock); err == errReorgDetected {
+			// The chain changed while the page was scanned; drop it and verify again
+			if err := f.guard.rollback(ctx, nextBlock, toBlock); err != nil {
+				log.Fatalf("Failed to roll back blocks %d to %d: %v", nextBlock, toBlock, err)
+			}
+			continue
+		} else if err != nil {
+			log.Fatalf("Failed to record indexed blocks: %v", err)
+		}
+		nextBlock = toBlock + 1
+	}
+	return nextBlock
+}
+
+// subscribe streams logs over the websocket endpoint. It returns the next block still to be
+// indexed when the subscription fails, so polling can take over without a gap.
+func (f *follower) subscribe(ctx context.Context, nextBlock uint64) (uint64, error) {
+	wsClient, err := ethclient.DialContext(ctx, f.wsURL)
+	if err != nil {
+		return nextBlock, err
+	}
+	defer wsClient.Close()
+
+	// Subscribe before catching up, so no block falls between the two
+	logs := make(chan types.Log, 256)
+	query := f.query
+	query.FromBlock, query.ToBlock = nil, nil
+	sub, err := wsClient.SubscribeFilterLogs(ctx, query, logs)
+	if err != nil {
+		return nextBlock, err
+	}
+	defer sub.Unsubscribe()
+
+	head, err := f.head(ctx)
+	if err != nil {
+		return nextBlock, err
+	}
+	nextBlock = f.catchUp(ctx, nextBlock, head)
+	log.Printf("Caught up to block %d, following new logs over websocket", head)
+
+	orphaned := make(map[common.Hash]bool) // Orphaned blocks that have already been rolled back
+	for {
+		select {
+		case <-ctx.Done():
+			return nextBlock, ctx.Err()
+		case <-f.restart:
+			return nextBlock, errFollowRestart
+		case err := <-sub.Err():
+			return nextBlock, err
+		case vLog := <-logs:
+			if vLog.Removed {
+				if orphaned[vLog.BlockHash] || vLog.BlockNumber > nextBlock {
+					continue
+				}
+				orphaned[vLog.BlockHash] = true
+
+				// The node dropped this log in a reorg; roll back and re-index from the canonical chain
+				log.Printf("Log in block %d was removed by a reorg (tx %s)", vLog.BlockNumber, vLog.TxHash.Hex())
+				if err := f.guard.rollback(ctx, vLog.BlockNumber, nextBlock); err != nil {
+					return nextBlock, err
+				}
+				head, err := f.head(ctx)
+				if err != nil {
+					return vLog.BlockNumber, err
+				}
+				nextBlock = f.catchUp(ctx, vLog.BlockNumber, head)
+				continue
+			}
+			if vLog.BlockNumber < nextBlock {
+				continue // Already indexed while catching up
+			}
+			// A log from a newer block means every earlier block has been delivered
+			if vLog.BlockNumber > nextBlock {
+				if err := f.guard.record(ctx, nextBlock, vLog.BlockNumber-1); err != nil {
+					return nextBlock, err
+				}
+				f.checkpoint(vLog.BlockNumber - 1)
+				nextBlock = vLog.BlockNumber
+			}
+			f.handleLog(vLog)
+		}
+	}
+}
+
+// poll checks for new heads over HTTP and indexes every block since the last one. It returns the
+// next block still to be indexed when ctx is cancelled or a restart is requested.
+func (f *follower) poll(ctx context.Context, nextBlock uint64) uint64 {
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+
+	for {
+		head, err := f.head(ctx)
+		if err != nil {
+			log.Printf("Failed to fetch the chain head: %v", err)
+		} else if head >= nextBlock {
+			nextBlock = f.catchUp(ctx, nextBlock, head)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nextBlock
+		case <-f.restart:
+			return nextBlock
+		case <-ticker.C:
+		}
+	}
+}
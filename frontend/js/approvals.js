@@ -0,0 +1,60 @@
+// Lists the allowances a wallet has given, from the api_server /api/approvals endpoint
+const form = document.getElementById("approvals-form");
+const walletInput = document.getElementById("approvals-wallet");
+const statusLine = document.getElementById("approvals-status");
+const approvalsBody = document.getElementById("approvals-body");
+
+// Formats a raw token amount with the token's decimals, when they are known
+function formatAmount(amount, decimals) {
+    if (decimals === null) {
+        return amount;
+    }
+    const padded = amount.padStart(decimals + 1, "0");
+    const whole = padded.slice(0, padded.length - decimals);
+    const fraction = padded.slice(padded.length - decimals).replace(/0+$/, "").slice(0, 6);
+    return fraction ? `${whole}.${fraction}` : whole;
+}
+
+function shortAddress(address) {
+    return `${address.slice(0, 6)}...${address.slice(-4)}`;
+}
+
+function renderAllowances(allowances) {
+    approvalsBody.innerHTML = "";
+    allowances.forEach(item => {
+        const token = item.token_symbol || shortAddress(item.token);
+        const spender = item.spender_label ? `${item.spender_label} (${shortAddress(item.spender)})` : item.spender;
+        const contract = item.spender_is_contract === null ? "?" : (item.spender_is_contract ? "Yes" : "No");
+        const age = item.spender_age_days === null ? "?" : `${item.spender_age_days} days`;
+        const amount = item.unlimited ? "Unlimited" : formatAmount(item.amount, item.token_decimals);
+        const approved = item.approved_at ? new Date(item.approved_at).toLocaleString() : `block ${item.approval_block}`;
+
+        const row = document.createElement("tr");
+        [token, spender, contract, age, amount, approved].forEach(text => {
+            const cell = document.createElement("td");
+            cell.textContent = text;
+            row.appendChild(cell);
+        });
+        approvalsBody.appendChild(row);
+    });
+}
+
+form.addEventListener("submit", event => {
+    event.preventDefault();
+    const wallet = walletInput.value.trim();
+    statusLine.textContent = "Loading...";
+
+    fetch(`/api/approvals?wallet=${encodeURIComponent(wallet)}`)
+        .then(response => response.json().then(body => ({ ok: response.ok, body })))
+        .then(({ ok, body }) => {
+            if (!ok) {
+                throw new Error(body.error);
+            }
+            renderAllowances(body.allowances);
+            statusLine.textContent = `${body.allowances.length} open allowances`;
+        })
+        .catch(err => {
+            approvalsBody.innerHTML = "";
+            statusLine.textContent = `Failed to load approvals: ${err.message}`;
+        });
+});
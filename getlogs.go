@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	filterLogsTimeout = 60 * time.Second // A single eth_getLogs call taking longer than this is split
+	sparseLogCount    = 1000             // Ranges returning fewer logs than this may grow
+	denseLogCount     = 5000             // Ranges returning more logs than this shrink
+)
+
+// Error messages providers use when an eth_getLogs call runs too long for them.
+var slowRangeMessages = []string{
+	"timeout",
+	"timed out",
+}
+
+// suggestedRangePattern matches the "[0x.., 0x..]" range some providers suggest in their error message.
+var suggestedRangePattern = regexp.MustCompile(`\[(0x[0-9a-fA-F]+),\s*(0x[0-9a-fA-F]+)\]`)
+
+// logPager pages through eth_getLogs, bisecting ranges the provider rejects and
+// growing the page again over sparse stretches of blocks.
+type logPager struct {
+	size    uint64 // Current page size in blocks
+	minSize uint64
+	maxSize uint64
+}
+
+// newLogPager returns a pager starting at the 10,000 block pages the monitors used to fetch.
+func newLogPager() *logPager {
+	return &logPager{size: 10000, minSize: 1, maxSize: 100000}
+}
+
+// pageEnd returns the last block of the page starting at fromBlock, capped at endBlock.
+func (p *logPager) pageEnd(fromBlock, endBlock uint64) uint64 {
+	toBlock := fromBlock + p.size - 1
+	if toBlock > endBlock {
+		toBlock = endBlock
+	}
+	return toBlock
+}
+
+// observe adjusts the page size after span blocks returned count logs.
+func (p *logPager) observe(span uint64, count int) {
+	switch {
+	case count > denseLogCount && span > p.minSize:
+		p.shrink(span / 2)
+	case count < sparseLogCount && span >= p.size && p.size < p.maxSize:
+		p.size *= 2
+		if p.size > p.maxSize {
+			p.size = p.maxSize
+		}
+	}
+}
+
+// shrink lowers the page size to at most size blocks.
+func (p *logPager) shrink(size uint64) {
+	if size < p.minSize {
+		size = p.minSize
+	}
+	if size < p.size {
+		p.size = size
+	}
+}
+
+// filterLogs returns the logs matching query in [fromBlock, toBlock]. A range the provider rejects
+// as too large or too slow is split, either where the provider suggests or in half, and fetched in parts.
+func (p *logPager) filterLogs(ctx context.Context, client logFetcher, query ethereum.FilterQuery, fromBlock, toBlock uint64) ([]types.Log, error) {
+	query.FromBlock = new(big.Int).SetUint64(fromBlock)
+	query.ToBlock = new(big.Int).SetUint64(toBlock)
+
+	// Errors that mean the range is too large are split below rather than retried
+	var logs []types.Log
+	err := retryRPC(ctx, "eth_getLogs", func(ctx context.Context) error {
+		// Wait for permission from the rate limiter
+		if err := limiter.Wait(ctx); err != nil {
+			return noRetry(err)
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, filterLogsTimeout)
+		defer cancel()
+		var err error
+		logs, err = client.FilterLogs(callCtx, query)
+		if err != nil && fromBlock < toBlock && isRangeTooLarge(err) {
+			return noRetry(err)
+		}
+		return err
+	})
+	if err == nil {
+		p.observe(toBlock-fromBlock+1, len(logs))
+		return logs, nil
+	}
+	if fromBlock == toBlock || !isRangeTooLarge(err) {
+		return nil, err
+	}
+
+	splitAt := fromBlock + (toBlock-fromBlock)/2
+	if suggested, ok := suggestedRangeEnd(err, fromBlock, toBlock); ok {
+		splitAt = suggested
+	}
+	p.shrink(splitAt - fromBlock + 1)
+	log.Printf("Range %d to %d rejected (%v), splitting at block %d", fromBlock, toBlock, err, splitAt)
+
+	left, err := p.filterLogs(ctx, client, query, fromBlock, splitAt)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.filterLogs(ctx, client, query, splitAt+1, toBlock)
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
+
+// collectLogs returns every log matching query in [fromBlock, toBlock], fetched page by page.
+func (p *logPager) collectLogs(ctx context.Context, client logFetcher, query ethereum.FilterQuery, fromBlock, toBlock uint64) ([]types.Log, error) {
+	var all []types.Log
+	for fromBlock <= toBlock {
+		pageEnd := p.pageEnd(fromBlock, toBlock)
+		logs, err := p.filterLogs(ctx, client, query, fromBlock, pageEnd)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, logs...)
+		fromBlock = pageEnd + 1
+	}
+	return all, nil
+}
+
+// isRangeTooLarge reports whether a FilterLogs error means the block range should be split.
+func isRangeTooLarge(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// Rate limits are backed off by retryRPC; splitting would only send more requests
+	switch classifyRPCError(err) {
+	case rpcRangeTooLarge:
+		return true
+	case rpcRateLimited:
+		return false
+	}
+	return containsAny(strings.ToLower(err.Error()), slowRangeMessages)
+}
+
+// suggestedRangeEnd returns the end of the range named in the provider's error message,
+// if there is one that lies strictly inside [fromBlock, toBlock).
+func suggestedRangeEnd(err error, fromBlock, toBlock uint64) (uint64, bool) {
+	match := suggestedRangePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	end, parseErr := strconv.ParseUint(strings.TrimPrefix(match[2], "0x"), 16, 64)
+	if parseErr != nil || end < fromBlock || end >= toBlock {
+		return 0, false
+	}
+	return end, true
+}
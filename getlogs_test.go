@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// codeError is a JSON-RPC error with a code, as providers return them.
+type codeError struct {
+	code    int
+	message string
+}
+
+func (e codeError) Error() string  { return e.message }
+func (e codeError) ErrorCode() int { return e.code }
+
+// fakeLogNode serves eth_getLogs with one log every 1000 blocks and rejects queries spanning
+// more than maxSpan blocks.
+type fakeLogNode struct {
+	maxSpan    uint64
+	rejection  func(fromBlock, toBlock uint64) error // Error for a query longer than maxSpan
+	rateLimits int                                   // Number of queries to refuse as over the request limit first
+
+	mu      sync.Mutex
+	queries [][2]uint64 // Every range asked for, in order
+}
+
+// GetLogs serves eth_getLogs.
+func (n *fakeLogNode) GetLogs(ctx context.Context, crit map[string]interface{}) ([]types.Log, error) {
+	fromBlock, err := hexutil.DecodeUint64(crit["fromBlock"].(string))
+	if err != nil {
+		return nil, err
+	}
+	toBlock, err := hexutil.DecodeUint64(crit["toBlock"].(string))
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	n.queries = append(n.queries, [2]uint64{fromBlock, toBlock})
+	rateLimited := n.rateLimits > 0
+	n.rateLimits--
+	n.mu.Unlock()
+
+	if rateLimited {
+		return nil, codeError{-32005, "limit exceeded"}
+	}
+	if toBlock-fromBlock+1 > n.maxSpan {
+		return nil, n.rejection(fromBlock, toBlock)
+	}
+	logs := []types.Log{}
+	for block := (fromBlock + 999) / 1000 * 1000; block <= toBlock; block += 1000 {
+		logs = append(logs, types.Log{BlockNumber: block, Topics: []common.Hash{}})
+	}
+	return logs, nil
+}
+
+// dialFakeNode serves node's methods under the eth namespace in process and connects a client to it.
+func dialFakeNode(t *testing.T, node interface{}) *ethclient.Client {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", node); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	t.Cleanup(func() {
+		client.Close()
+		server.Stop()
+	})
+	return ethclient.NewClient(client)
+}
+
+func tooManyResults(fromBlock, toBlock uint64) error {
+	return codeError{-32602, "Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range"}
+}
+
+func TestLogPagerAdaptsToDensity(t *testing.T) {
+	pager := newLogPager()
+
+	// A quiet stretch of full pages doubles the size up to the maximum
+	for _, want := range []uint64{20000, 40000, 80000, 100000, 100000} {
+		pager.observe(pager.size, 10)
+		if pager.size != want {
+			t.Fatalf("size after a sparse full page = %d, want %d", pager.size, want)
+		}
+	}
+
+	// The short last page of a range says nothing about the next one
+	pager.observe(500, 0)
+	if pager.size != 100000 {
+		t.Errorf("size after a sparse short page = %d, want 100000", pager.size)
+	}
+
+	// A busy page halves its span, an ordinary one keeps the size
+	pager.observe(100000, 6000)
+	if pager.size != 50000 {
+		t.Errorf("size after a dense page = %d, want 50000", pager.size)
+	}
+	pager.observe(50000, 3000)
+	if pager.size != 50000 {
+		t.Errorf("size after an ordinary page = %d, want 50000", pager.size)
+	}
+
+	// A busy short page shrinks below its own span; a single busy block cannot be split
+	pager.observe(2000, 6000)
+	if pager.size != 1000 {
+		t.Errorf("size after a dense short page = %d, want 1000", pager.size)
+	}
+	pager.observe(1, 9000)
+	if pager.size != 1000 {
+		t.Errorf("size after a dense single block = %d, want 1000", pager.size)
+	}
+}
+
+func TestLogPagerPageEnd(t *testing.T) {
+	pager := newLogPager()
+	if got := pager.pageEnd(100, 1000000); got != 10099 {
+		t.Errorf("pageEnd(100, 1000000) = %d, want 10099", got)
+	}
+	if got := pager.pageEnd(100, 500); got != 500 {
+		t.Errorf("pageEnd(100, 500) = %d, want 500", got)
+	}
+}
+
+func TestIsRangeTooLarge(t *testing.T) {
+	split := []error{
+		fmt.Errorf("eth_getLogs: %w", context.DeadlineExceeded),
+		codeError{-32005, "query returned more than 10000 results"},
+		codeError{-32602, "eth_getLogs is limited to a 10,000 block range"},
+		codeError{-32000, "too many logs in the requested range"},
+		errors.New("request timed out"),
+	}
+	for _, err := range split {
+		if !isRangeTooLarge(err) {
+			t.Errorf("isRangeTooLarge(%v) = false, want the range split", err)
+		}
+	}
+
+	// Rate limits are backed off rather than split into more requests
+	keep := []error{
+		codeError{-32005, "limit exceeded"},
+		codeError{429, "429 Too Many Requests"},
+		errors.New("too many requests, slow down"),
+		errors.New("read tcp: connection reset by peer"),
+		codeError{3, "execution reverted"},
+	}
+	for _, err := range keep {
+		if isRangeTooLarge(err) {
+			t.Errorf("isRangeTooLarge(%v) = true, want the error returned", err)
+		}
+	}
+}
+
+func TestFilterLogsSplitsRejectedRanges(t *testing.T) {
+	suggestRange := func(fromBlock, toBlock uint64) error {
+		return codeError{-32602, fmt.Sprintf("Log response size exceeded. Based on your parameters, this block range should work: [0x%x, 0x%x]", fromBlock, fromBlock+2999)}
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		node := &fakeLogNode{maxSpan: 10000}
+		checkFilterLogs(t, node, [][2]uint64{{0, 9999}})
+	})
+	t.Run("halved until accepted", func(t *testing.T) {
+		node := &fakeLogNode{maxSpan: 2500, rejection: tooManyResults}
+		checkFilterLogs(t, node, [][2]uint64{
+			{0, 9999}, {0, 4999}, {0, 2499}, {2500, 4999}, {5000, 9999}, {5000, 7499}, {7500, 9999},
+		})
+	})
+	t.Run("split where the provider suggests", func(t *testing.T) {
+		node := &fakeLogNode{maxSpan: 3000, rejection: suggestRange}
+		checkFilterLogs(t, node, [][2]uint64{
+			{0, 9999}, {0, 2999}, {3000, 9999}, {3000, 5999}, {6000, 9999}, {6000, 8999}, {9000, 9999},
+		})
+	})
+	t.Run("split on the results limit", func(t *testing.T) {
+		node := &fakeLogNode{maxSpan: 5000, rejection: func(fromBlock, toBlock uint64) error {
+			return codeError{-32005, "query returned more than 10000 results"}
+		}}
+		checkFilterLogs(t, node, [][2]uint64{{0, 9999}, {0, 4999}, {5000, 9999}})
+	})
+	t.Run("rate limit retried, not split", func(t *testing.T) {
+		node := &fakeLogNode{maxSpan: 10000, rateLimits: 1}
+		checkFilterLogs(t, node, [][2]uint64{{0, 9999}, {0, 9999}})
+	})
+}
+
+func TestFilterLogsRejectedBlock(t *testing.T) {
+	// A single block cannot be split further, so its rejection is returned at once
+	node := &fakeLogNode{maxSpan: 0, rejection: tooManyResults}
+	_, err := newLogPager().filterLogs(context.Background(), dialFakeNode(t, node), ethereum.FilterQuery{}, 7, 7)
+	if !isRPCErrorKind(err, rpcRangeTooLarge) || len(node.queries) != 1 {
+		t.Errorf("filterLogs of a rejected block = %v after %d queries, want a range too large RPCError after 1", err, len(node.queries))
+	}
+}
+
+// checkFilterLogs fetches blocks 0 to 9999 from node and checks that every log arrives once, in
+// block order, after the given queries.
+func checkFilterLogs(t *testing.T, node *fakeLogNode, wantQueries [][2]uint64) {
+	t.Helper()
+	logs, err := newLogPager().filterLogs(context.Background(), dialFakeNode(t, node), ethereum.FilterQuery{}, 0, 9999)
+	if err != nil {
+		t.Fatalf("filterLogs failed: %v", err)
+	}
+	if len(logs) != 10 {
+		t.Fatalf("filterLogs returned %d logs, want 10", len(logs))
+	}
+	for i, vLog := range logs {
+		if vLog.BlockNumber != uint64(i)*1000 {
+			t.Fatalf("log %d is from block %d, want %d", i, vLog.BlockNumber, i*1000)
+		}
+	}
+	if !reflect.DeepEqual(node.queries, wantQueries) {
+		t.Errorf("filterLogs queried %v, want %v", node.queries, wantQueries)
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+import "golang.org/x/time/rate"
+
+// limiter stands in for the one each program defines, so the shared files can be tested without a main.
+var limiter = rate.NewLimiter(rate.Inf, 1)
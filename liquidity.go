@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	rugWindowBlocks = 43200 // One day of 2 second Base blocks after a pool's creation
+	rugRemovedShare = 0.8   // Share of the added liquidity whose removal raises an alert
+)
+
+// LiquidityRecord is liquidity added to or removed from a pool, or tokens collected from a position.
+type LiquidityRecord struct {
+	Pool      common.Address
+	Kind      string         // mint, burn or collect
+	Owner     common.Address // Caller or position owner named by the event
+	Recipient common.Address // Receiver of the withdrawn tokens; zero for mints
+	Amount0   *big.Int
+	Amount1   *big.Int
+	Liquidity *big.Int // Liquidity units added or removed; nil for collects
+	TickLower sql.NullInt64
+	TickUpper sql.NullInt64
+	Log       types.Log
+}
+
+// The liquidity events of every supported pool type.
+func init() {
+	liquidity := registerEventGroup("liquidity", "liquidity_events", "rug_alerts")
+	liquidity.Senders = true
+	liquidity.BeforeCommit = checkRugPulls
+
+	// Uniswap V2 and its forks, Solidly forks and Aerodrome
+	liquidity.register("event Mint(address indexed sender, uint256 amount0, uint256 amount1)",
+		reserveLiquidity("mint", "sender", ""))
+
+	// Uniswap V2 and its forks, and Solidly forks
+	liquidity.register("event Burn(address indexed sender, uint256 amount0, uint256 amount1, address indexed to)",
+		reserveLiquidity("burn", "sender", "to"))
+
+	// Aerodrome
+	liquidity.register("event Burn(address indexed sender, address indexed to, uint256 amount0, uint256 amount1)",
+		reserveLiquidity("burn", "sender", "to"))
+
+	// Uniswap V3 and its forks
+	liquidity.register("event Mint(address sender, address indexed owner, int24 indexed tickLower, int24 indexed tickUpper, uint128 amount, uint256 amount0, uint256 amount1)",
+		concentratedLiquidity("mint", "owner", "", "amount"))
+	liquidity.register("event Burn(address indexed owner, int24 indexed tickLower, int24 indexed tickUpper, uint128 amount, uint256 amount0, uint256 amount1)",
+		concentratedLiquidity("burn", "owner", "", "amount"))
+	liquidity.register("event Collect(address indexed owner, address recipient, int24 indexed tickLower, int24 indexed tickUpper, uint128 amount0, uint128 amount1)",
+		concentratedLiquidity("collect", "owner", "recipient", ""))
+}
+
+// reserveLiquidity decodes a mint or burn of a constant-product pool. These events carry no
+// liquidity amount, so sqrt(amount0*amount1) is used, which matches the LP tokens minted or
+// burned as long as the deposit is proportional to the reserves.
+func reserveLiquidity(kind, owner, recipient string) func(vLog types.Log, values map[string]interface{}) error {
+	return func(vLog types.Log, values map[string]interface{}) error {
+		record := LiquidityRecord{Pool: vLog.Address, Kind: kind, Log: vLog}
+		var err error
+		if record.Owner, err = addressValue(values, owner); err != nil {
+			return err
+		}
+		if recipient != "" {
+			if record.Recipient, err = addressValue(values, recipient); err != nil {
+				return err
+			}
+		}
+		if record.Amount0, err = bigValue(values, "amount0"); err != nil {
+			return err
+		}
+		if record.Amount1, err = bigValue(values, "amount1"); err != nil {
+			return err
+		}
+		record.Liquidity = new(big.Int).Sqrt(new(big.Int).Mul(record.Amount0, record.Amount1))
+		insertLiquidity(record)
+		return nil
+	}
+}
+
+// concentratedLiquidity decodes a mint, burn or collect of a concentrated-liquidity position.
+// liquidity names the argument holding the liquidity amount, or is empty for collects.
+func concentratedLiquidity(kind, owner, recipient, liquidity string) func(vLog types.Log, values map[string]interface{}) error {
+	return func(vLog types.Log, values map[string]interface{}) error {
+		record := LiquidityRecord{Pool: vLog.Address, Kind: kind, Log: vLog}
+		var err error
+		if record.Owner, err = addressValue(values, owner); err != nil {
+			return err
+		}
+		if recipient != "" {
+			if record.Recipient, err = addressValue(values, recipient); err != nil {
+				return err
+			}
+		}
+		if record.Amount0, err = bigValue(values, "amount0"); err != nil {
+			return err
+		}
+		if record.Amount1, err = bigValue(values, "amount1"); err != nil {
+			return err
+		}
+		if liquidity != "" {
+			if record.Liquidity, err = bigValue(values, liquidity); err != nil {
+				return err
+			}
+		}
+
+		tickLower, err := intValue(values, "tickLower", -1<<23, 1<<23-1)
+		if err != nil {
+			return err
+		}
+		tickUpper, err := intValue(values, "tickUpper", -1<<23, 1<<23-1)
+		if err != nil {
+			return err
+		}
+		record.TickLower = sql.NullInt64{Int64: tickLower, Valid: true}
+		record.TickUpper = sql.NullInt64{Int64: tickUpper, Valid: true}
+
+		insertLiquidity(record)
+		return nil
+	}
+}
+
+// liquidityTable writes liquidity events keyed by their log, so rescanning a range rewrites the same rows.
+var liquidityTable = &batchTable{
+	name: "liquidity_events",
+	columns: []string{"chain_id", "pool_address", "block_number", "block_hash", "block_timestamp", "tx_hash", "log_index",
+		"kind", "provider", "owner", "recipient", "amount0", "amount1", "liquidity", "tick_lower", "tick_upper", "event_signature"},
+	conflict: `
+        ON CONFLICT (chain_id, tx_hash, log_index) DO UPDATE SET
+            pool_address    = EXCLUDED.pool_address,
+            block_number    = EXCLUDED.block_number,
+            block_hash      = EXCLUDED.block_hash,
+            block_timestamp = COALESCE(EXCLUDED.block_timestamp, liquidity_events.block_timestamp),
+            kind            = EXCLUDED.kind,
+            provider        = COALESCE(EXCLUDED.provider, liquidity_events.provider),
+            owner           = EXCLUDED.owner,
+            recipient       = EXCLUDED.recipient,
+            amount0         = EXCLUDED.amount0,
+            amount1         = EXCLUDED.amount1,
+            liquidity       = EXCLUDED.liquidity,
+            tick_lower      = EXCLUDED.tick_lower,
+            tick_upper      = EXCLUDED.tick_upper,
+            event_signature = EXCLUDED.event_signature
+    `,
+	key: []int{0, 5, 6},
+}
+
+// insertLiquidity queues a liquidity event, attributed to the account that sent its transaction:
+// the events themselves usually name a router or position manager.
+func insertLiquidity(record LiquidityRecord) {
+	vLog := record.Log
+
+	var provider sql.NullString
+	if sender, err := transactionSender(vLog.TxHash); err != nil {
+		log.Printf("Failed to get the sender of transaction %s: %v", vLog.TxHash.Hex(), err)
+	} else {
+		provider = sql.NullString{String: sender.Hex(), Valid: true}
+	}
+
+	var recipient sql.NullString
+	if record.Recipient != (common.Address{}) {
+		recipient = sql.NullString{String: record.Recipient.Hex(), Valid: true}
+	}
+
+	rowWriter.add(liquidityTable, chainID, record.Pool.Hex(), vLog.BlockNumber, vLog.BlockHash.Hex(), logTimestamp(vLog), vLog.TxHash.Hex(), vLog.Index,
+		record.Kind, provider, record.Owner.Hex(), recipient, record.Amount0.String(), record.Amount1.String(),
+		numericOrNull(record.Liquidity), record.TickLower, record.TickUpper, eventSignature(vLog))
+}
+
+// rugPullShare returns the share of the liquidity minted into a pool that has been burned, and
+// whether it is enough to raise an alert. The totals are decimal strings; nothing minted is never an alert.
+func rugPullShare(minted, burned string) (float64, bool) {
+	mintedTotal, ok := new(big.Float).SetString(minted)
+	if !ok || mintedTotal.Sign() <= 0 {
+		return 0, false
+	}
+	burnedTotal, ok := new(big.Float).SetString(burned)
+	if !ok {
+		return 0, false
+	}
+	share, _ := new(big.Float).Quo(burnedTotal, mintedTotal).Float64()
+	return share, share >= rugRemovedShare
+}
+
+// rugPull is a burn that may have removed most of a young pool's liquidity.
+type rugPull struct {
+	pool, blockHash, txHash string
+	provider, deployer      sql.NullString
+	blockNumber, age        int64
+	minted, burned          string // Liquidity minted and burned up to and including the burn
+}
+
+// checkRugPulls raises an alert for every pool that lost at least rugRemovedShare of the liquidity
+// added to it through burns in [fromBlock, toBlock], within rugWindowBlocks of its creation. It runs
+// in the transaction storing the range, so alerts are committed with the liquidity events and the
+// checkpoint. Each pool is alerted once, naming the deployer recorded in pairs.
+func checkRugPulls(tx *sql.Tx, fromBlock, toBlock uint64) error {
+	// The totals are summed along liquidity_events_pool_kind and only for burns in a pool's first
+	// rugWindowBlocks, so each sum covers at most a day of the pool's events
+	query := `
+        SELECT b.pool_address, b.block_number, b.block_hash, b.tx_hash, b.provider, p.deployer_address,
+               b.block_number - p.block_number, minted.total::text, burned.total::text
+        FROM liquidity_events b
+        JOIN pairs p ON p.chain_id = b.chain_id AND p.pair_address = b.pool_address
+        CROSS JOIN LATERAL (
+            SELECT COALESCE(sum(e.liquidity), 0) AS total FROM liquidity_events e
+            WHERE e.chain_id = b.chain_id AND e.pool_address = b.pool_address AND e.kind = 'mint'
+              AND (e.block_number, e.log_index) <= (b.block_number, b.log_index)
+        ) minted
+        CROSS JOIN LATERAL (
+            SELECT COALESCE(sum(e.liquidity), 0) AS total FROM liquidity_events e
+            WHERE e.chain_id = b.chain_id AND e.pool_address = b.pool_address AND e.kind = 'burn'
+              AND (e.block_number, e.log_index) <= (b.block_number, b.log_index)
+        ) burned
+        WHERE b.chain_id = $1 AND b.kind = 'burn' AND b.block_number BETWEEN $2 AND $3
+          AND b.block_number <= p.block_number + $4
+        ORDER BY b.block_number, b.log_index
+    `
+	rows, err := tx.Query(query, chainID, fromBlock, toBlock, rugWindowBlocks)
+	if err != nil {
+		return fmt.Errorf("failed to check for rug pulls: %w", err)
+	}
+	var burns []rugPull
+	for rows.Next() {
+		var b rugPull
+		if err := rows.Scan(&b.pool, &b.blockNumber, &b.blockHash, &b.txHash, &b.provider, &b.deployer, &b.age, &b.minted, &b.burned); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to check for rug pulls: %w", err)
+		}
+		burns = append(burns, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check for rug pulls: %w", err)
+	}
+
+	for _, b := range burns {
+		share, alert := rugPullShare(b.minted, b.burned)
+		if !alert {
+			continue
+		}
+
+		insert := `
+            INSERT INTO rug_alerts (chain_id, pool_address, deployer_address, provider, block_number, block_hash, tx_hash,
+                                    removed_share, blocks_after_creation)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+            ON CONFLICT (chain_id, pool_address) DO NOTHING
+        `
+		result, err := tx.Exec(insert, chainID, b.pool, b.deployer, b.provider, b.blockNumber, b.blockHash, b.txHash, share, b.age)
+		if err != nil {
+			return fmt.Errorf("failed to store the rug-pull alert for %s: %w", b.pool, err)
+		}
+		if raised, _ := result.RowsAffected(); raised == 0 {
+			continue // Already alerted
+		}
+
+		deployerName := "unknown"
+		if b.deployer.Valid {
+			deployerName = b.deployer.String
+		}
+		log.Printf("RUG-PULL ALERT: %.0f%% of the liquidity of pool %s was removed %d blocks after creation (tx %s by %s), deployer %s",
+			share*100, b.pool, b.age, b.txHash, b.provider.String, deployerName)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestRugPullShare(t *testing.T) {
+	thresholds := []struct {
+		minted, burned string
+		share          float64
+		alert          bool
+	}{
+		{"1000", "800", 0.8, true}, // Exactly rugRemovedShare
+		{"1000", "1000", 1, true},
+		{"1000", "799", 0.799, false},
+		{"1000", "0", 0, false},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639935", "100000000000000000000000000000000000000000000000000000000000000000000000000000", 0.8636168555094445, true},
+		{"0", "500", 0, false}, // Burns without recorded mints, such as in pools older than the index
+		{"", "500", 0, false},
+	}
+	for _, th := range thresholds {
+		share, alert := rugPullShare(th.minted, th.burned)
+		if alert != th.alert || share != th.share {
+			t.Errorf("rugPullShare(%s, %s) = %v, %v; want %v, %v", th.minted, th.burned, share, alert, th.share, th.alert)
+		}
+	}
+}
+
+func TestCheckRugPullsCommitsWithTheRange(t *testing.T) {
+	fake := useFakeDB(t)
+	columns := []string{"pool_address", "block_number", "block_hash", "tx_hash", "provider", "deployer_address", "age", "minted", "burned"}
+	fake.query = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if !strings.Contains(query, "FROM liquidity_events b") {
+			return nil, nil, nil
+		}
+		return columns, [][]driver.Value{
+			{"0xa", int64(150), "0xb1", "0xt1", "0xp", "0xd", int64(50), "1000", "500"}, // Half of it, no alert
+			{"0xa", int64(160), "0xb2", "0xt2", "0xp", "0xd", int64(60), "1000", "900"}, // Now 90%
+			{"0xc", int64(170), "0xb3", "0xt3", "0xp", nil, int64(70), "1000", "1000"},
+		}, nil
+	}
+	fake.exec = func(query string, args []driver.Value) (int64, error) {
+		if strings.Contains(query, "rug_alerts") && args[1] == "0xc" {
+			return 0, nil // Already alerted
+		}
+		return 1, nil
+	}
+
+	commitEventLogs(nil, []*eventGroup{eventGroups["liquidity"]}, []string{"pool_monitor:liquidity"}, 100, 199)
+
+	var got []string
+	for _, statement := range fake.executed() {
+		switch {
+		case strings.Contains(statement.query, "FROM liquidity_events b"):
+			if statement.args[1] != int64(100) || statement.args[2] != int64(199) || statement.args[3] != int64(rugWindowBlocks) {
+				t.Errorf("rug pulls checked with %v, want blocks 100 to 199 and a window of %d", statement.args[1:], rugWindowBlocks)
+			}
+			got = append(got, "check")
+		case strings.Contains(statement.query, "INSERT INTO rug_alerts"):
+			got = append(got, "alert "+statement.args[1].(string))
+		case strings.Contains(statement.query, "INSERT INTO scan_checkpoints"):
+			got = append(got, "checkpoint")
+		case statement.query == "BEGIN", statement.query == "COMMIT":
+			got = append(got, statement.query)
+		}
+	}
+	want := "BEGIN check alert 0xa alert 0xc checkpoint COMMIT"
+	if strings.Join(got, " ") != want {
+		t.Errorf("statements = %s, want %s", strings.Join(got, " "), want)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const migrationLockID = 7319402 // Advisory lock held while migrating, so two programs never migrate at once
+
+// migration is one versioned schema change with the SQL to apply and revert it.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the embedded migrations, named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, ordered by version.
+func loadMigrations() []migration {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base := strings.TrimSuffix(fileName, ".sql")
+		direction := base[strings.LastIndex(base, ".")+1:]
+		base = strings.TrimSuffix(base, "."+direction)
+
+		separator := strings.Index(base, "_")
+		if separator < 0 || (direction != "up" && direction != "down") {
+			log.Fatalf("Unexpected migration file name %s", fileName)
+		}
+		version, err := strconv.Atoi(base[:separator])
+		if err != nil {
+			log.Fatalf("Unexpected migration file name %s", fileName)
+		}
+
+		contents, err := migrationFiles.ReadFile("migrations/" + fileName)
+		if err != nil {
+			log.Fatalf("Failed to read migration %s: %v", fileName, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: base[separator+1:]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(contents)
+		} else {
+			m.down = string(contents)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			log.Fatalf("Migration %04d_%s needs both an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations
+}
+
+// withMigrationLock runs fn on a single connection holding the migration advisory lock.
+func withMigrationLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	query := `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version    INTEGER PRIMARY KEY,
+            name       TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )
+    `
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return fn(ctx, conn)
+}
+
+// appliedMigrations returns when each applied version was applied.
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes one migration's SQL and updates schema_migrations in a single transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, m migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if _, err := tx.ExecContext(ctx, m.up); err != nil {
+			return fmt.Errorf("migration %04d_%s up: %v", m.version, m.name, err)
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name)
+	} else {
+		if _, err := tx.ExecContext(ctx, m.down); err != nil {
+			return fmt.Errorf("migration %04d_%s down: %v", m.version, m.name, err)
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateUp applies every migration that has not been applied yet, in version order.
+func migrateUp() {
+	err := withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range loadMigrations() {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			log.Printf("Applying migration %04d_%s", m.version, m.name)
+			if err := runMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to migrate the database: %v", err)
+	}
+}
+
+// migrateDown reverts the latest steps applied migrations.
+func migrateDown(steps int) {
+	err := withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		migrations := loadMigrations()
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			log.Printf("Reverting migration %04d_%s", m.version, m.name)
+			if err := runMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to revert migrations: %v", err)
+	}
+}
+
+// migrateStatus prints every known migration and whether it has been applied.
+func migrateStatus() {
+	err := withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range loadMigrations() {
+			status := "pending"
+			if appliedAt, ok := applied[m.version]; ok {
+				status = "applied " + appliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%-30s %s\n", m.version, m.name, status)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to read migration status: %v", err)
+	}
+}
+
+// runMigrateCommand handles "migrate up", "migrate down [steps]" and "migrate status".
+func runMigrateCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("Usage: migrate up|down [steps]|status")
+	}
+	switch args[0] {
+	case "up":
+		migrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q", args[1])
+			}
+		}
+		migrateDown(steps)
+	case "status":
+		migrateStatus()
+	default:
+		log.Fatalf("Unknown migrate command %q; use up, down or status", args[0])
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS pairs (
+    pair_address     TEXT NOT NULL,
+    token0_address   TEXT NOT NULL,
+    token1_address   TEXT NOT NULL,
+    deployer_address TEXT,
+    factory_address  TEXT NOT NULL
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS scan_checkpoints (
+    scanner    TEXT PRIMARY KEY,
+    last_block BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
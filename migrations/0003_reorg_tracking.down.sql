@@ -0,0 +1,5 @@
+ALTER TABLE pairs
+    DROP COLUMN IF EXISTS block_hash,
+    DROP COLUMN IF EXISTS block_number;
+
+DROP TABLE IF EXISTS indexed_blocks;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS indexed_blocks (
+    scanner      TEXT NOT NULL,
+    block_number BIGINT NOT NULL,
+    block_hash   TEXT NOT NULL,
+    parent_hash  TEXT NOT NULL,
+    PRIMARY KEY (scanner, block_number)
+);
+
+ALTER TABLE pairs
+    ADD COLUMN IF NOT EXISTS block_number BIGINT,
+    ADD COLUMN IF NOT EXISTS block_hash TEXT;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS quarantined_logs (
+    tx_hash        TEXT NOT NULL,
+    log_index      INTEGER NOT NULL,
+    block_number   BIGINT NOT NULL,
+    block_hash     TEXT NOT NULL,
+    address        TEXT NOT NULL,
+    topics         TEXT NOT NULL,
+    data           TEXT NOT NULL,
+    reason         TEXT NOT NULL,
+    quarantined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tx_hash, log_index)
+);
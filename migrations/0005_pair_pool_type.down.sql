@@ -0,0 +1,5 @@
+ALTER TABLE pairs
+    DROP COLUMN IF EXISTS tick_spacing,
+    DROP COLUMN IF EXISTS fee,
+    DROP COLUMN IF EXISTS stable,
+    DROP COLUMN IF EXISTS pool_type;
@@ -0,0 +1,5 @@
+ALTER TABLE pairs
+    ADD COLUMN IF NOT EXISTS pool_type TEXT,
+    ADD COLUMN IF NOT EXISTS stable BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS fee INTEGER,
+    ADD COLUMN IF NOT EXISTS tick_spacing INTEGER;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS pairs_chain_pool;
+
+ALTER TABLE pairs
+    DROP COLUMN IF EXISTS event_signature,
+    DROP COLUMN IF EXISTS log_index,
+    DROP COLUMN IF EXISTS tx_hash,
+    DROP COLUMN IF EXISTS block_timestamp,
+    DROP COLUMN IF EXISTS chain_id;
@@ -0,0 +1,17 @@
+ALTER TABLE pairs
+    ADD COLUMN IF NOT EXISTS chain_id BIGINT,
+    ADD COLUMN IF NOT EXISTS block_timestamp TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS tx_hash TEXT,
+    ADD COLUMN IF NOT EXISTS log_index INTEGER,
+    ADD COLUMN IF NOT EXISTS event_signature TEXT;
+
+-- The deployer is looked up separately and may be unknown
+ALTER TABLE pairs ALTER COLUMN deployer_address DROP NOT NULL;
+
+-- Earlier reruns inserted duplicates; keep the first copy of each pool
+DELETE FROM pairs a USING pairs b
+WHERE a.ctid > b.ctid
+  AND a.pair_address = b.pair_address
+  AND a.chain_id IS NOT DISTINCT FROM b.chain_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS pairs_chain_pool ON pairs (chain_id, pair_address);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    chain_id         BIGINT NOT NULL,
+    address          TEXT NOT NULL,
+    first_seen_block BIGINT NOT NULL,
+    name             TEXT,
+    symbol           TEXT,
+    decimals         SMALLINT,
+    total_supply     NUMERIC(78, 0),
+    fetched_at       TIMESTAMPTZ,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT,
+    PRIMARY KEY (chain_id, address)
+);
+
+-- Tokens still waiting for their metadata, oldest first
+CREATE INDEX IF NOT EXISTS tokens_pending ON tokens (chain_id, first_seen_block) WHERE fetched_at IS NULL;
+
+-- Tokens of pools indexed before this table existed
+INSERT INTO tokens (chain_id, address, first_seen_block)
+SELECT chain_id, token, MIN(block_number)
+FROM (
+    SELECT chain_id, token0_address AS token, block_number FROM pairs
+    UNION ALL
+    SELECT chain_id, token1_address AS token, block_number FROM pairs
+) seen
+WHERE chain_id IS NOT NULL AND block_number IS NOT NULL
+GROUP BY chain_id, token
+ON CONFLICT (chain_id, address) DO NOTHING;
@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS factory_review_queue;
+DROP INDEX IF EXISTS pairs_chain_factory;
+DROP TABLE IF EXISTS factory_candidates;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS factory_candidates (
+    chain_id         BIGINT NOT NULL,
+    address          TEXT NOT NULL,
+    first_seen_block BIGINT NOT NULL,
+    verified         BOOLEAN,
+    verify_note      TEXT,
+    verified_at      TIMESTAMPTZ,
+    status           TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    PRIMARY KEY (chain_id, address)
+);
+
+CREATE INDEX IF NOT EXISTS pairs_chain_factory ON pairs (chain_id, factory_address);
+
+-- Candidates awaiting review, with how many pools each has created so far
+CREATE OR REPLACE VIEW factory_review_queue AS
+SELECT c.chain_id, c.address, c.first_seen_block, c.verified, c.verify_note,
+       (SELECT count(*) FROM pairs p WHERE p.chain_id = c.chain_id AND p.factory_address = c.address) AS pool_count
+FROM factory_candidates c
+WHERE c.status = 'pending';
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS factories (
+    chain_id         BIGINT NOT NULL,
+    address          TEXT NOT NULL,
+    entity_id        TEXT NOT NULL,
+    dex_type         TEXT CHECK (dex_type IN ('v2', 'v3', 'solidly')),
+    router           TEXT,
+    init_code_hash   TEXT,
+    fee              INTEGER,
+    deployment_block BIGINT,
+    enabled          BOOLEAN NOT NULL DEFAULT true,
+    added_at         TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (chain_id, address)
+);
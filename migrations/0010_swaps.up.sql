@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS swaps (
+    chain_id        BIGINT NOT NULL,
+    pool_address    TEXT NOT NULL,
+    block_number    BIGINT NOT NULL,
+    block_hash      TEXT NOT NULL,
+    block_timestamp TIMESTAMPTZ,
+    tx_hash         TEXT NOT NULL,
+    log_index       INTEGER NOT NULL,
+    sender          TEXT NOT NULL,
+    recipient       TEXT NOT NULL,
+    amount0_in      NUMERIC(78, 0) NOT NULL,
+    amount1_in      NUMERIC(78, 0) NOT NULL,
+    amount0_out     NUMERIC(78, 0) NOT NULL,
+    amount1_out     NUMERIC(78, 0) NOT NULL,
+    sqrt_price_x96  NUMERIC(78, 0),
+    liquidity       NUMERIC(78, 0),
+    tick            INTEGER,
+    event_signature TEXT NOT NULL,
+    PRIMARY KEY (chain_id, tx_hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS swaps_pool_block ON swaps (chain_id, pool_address, block_number);
+CREATE INDEX IF NOT EXISTS swaps_block ON swaps (block_number);
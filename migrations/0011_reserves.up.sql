@@ -0,0 +1,15 @@
+-- Pool reserves after the last Sync of each block
+CREATE TABLE IF NOT EXISTS reserves (
+    chain_id        BIGINT NOT NULL,
+    pool_address    TEXT NOT NULL,
+    block_number    BIGINT NOT NULL,
+    block_hash      TEXT NOT NULL,
+    block_timestamp TIMESTAMPTZ,
+    tx_hash         TEXT NOT NULL,
+    log_index       INTEGER NOT NULL,
+    reserve0        NUMERIC(78, 0) NOT NULL,
+    reserve1        NUMERIC(78, 0) NOT NULL,
+    PRIMARY KEY (chain_id, pool_address, block_number)
+);
+
+CREATE INDEX IF NOT EXISTS reserves_block ON reserves (block_number);
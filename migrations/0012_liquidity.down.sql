@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS rug_alerts;
+DROP TABLE IF EXISTS liquidity_events;
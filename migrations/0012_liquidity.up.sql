@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS liquidity_events (
+    chain_id        BIGINT NOT NULL,
+    pool_address    TEXT NOT NULL,
+    block_number    BIGINT NOT NULL,
+    block_hash      TEXT NOT NULL,
+    block_timestamp TIMESTAMPTZ,
+    tx_hash         TEXT NOT NULL,
+    log_index       INTEGER NOT NULL,
+    kind            TEXT NOT NULL CHECK (kind IN ('mint', 'burn', 'collect')),
+    provider        TEXT,           -- Account that sent the transaction
+    owner           TEXT NOT NULL,  -- Caller or position owner named by the event
+    recipient       TEXT,           -- Receiver of withdrawn tokens; burns and collects only
+    amount0         NUMERIC(78, 0) NOT NULL,
+    amount1         NUMERIC(78, 0) NOT NULL,
+    liquidity       NUMERIC(78, 0), -- Liquidity units added or removed; NULL for collects
+    tick_lower      INTEGER,
+    tick_upper      INTEGER,
+    event_signature TEXT NOT NULL,
+    PRIMARY KEY (chain_id, tx_hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS liquidity_events_pool_block ON liquidity_events (chain_id, pool_address, block_number);
+CREATE INDEX IF NOT EXISTS liquidity_events_provider ON liquidity_events (chain_id, provider);
+CREATE INDEX IF NOT EXISTS liquidity_events_block ON liquidity_events (block_number);
+
+CREATE TABLE IF NOT EXISTS rug_alerts (
+    chain_id              BIGINT NOT NULL,
+    pool_address          TEXT NOT NULL,
+    deployer_address      TEXT,
+    provider              TEXT,
+    block_number          BIGINT NOT NULL,
+    block_hash            TEXT NOT NULL,
+    tx_hash               TEXT NOT NULL,
+    removed_share         DOUBLE PRECISION NOT NULL,
+    blocks_after_creation BIGINT NOT NULL,
+    raised_at             TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (chain_id, pool_address)
+);
+
+CREATE INDEX IF NOT EXISTS rug_alerts_block ON rug_alerts (block_number);
+CREATE INDEX IF NOT EXISTS rug_alerts_deployer ON rug_alerts (chain_id, deployer_address);
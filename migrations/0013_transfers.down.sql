@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS token_balances;
+DROP TABLE IF EXISTS transfers;
+DROP FUNCTION IF EXISTS transfers_deleted();
+DROP FUNCTION IF EXISTS transfers_inserted();
+ALTER TABLE tokens DROP COLUMN IF EXISTS deployment_block;
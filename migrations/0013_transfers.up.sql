@@ -0,0 +1,80 @@
+-- First block with the token's code, found by token_monitor; its transfer history starts here
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS deployment_block BIGINT;
+
+CREATE TABLE IF NOT EXISTS transfers (
+    chain_id        BIGINT NOT NULL,
+    token_address   TEXT NOT NULL,
+    block_number    BIGINT NOT NULL,
+    block_hash      TEXT NOT NULL,
+    block_timestamp TIMESTAMPTZ,
+    tx_hash         TEXT NOT NULL,
+    log_index       INTEGER NOT NULL,
+    from_address    TEXT NOT NULL,
+    to_address      TEXT NOT NULL,
+    value           NUMERIC(78, 0) NOT NULL,
+    PRIMARY KEY (chain_id, tx_hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS transfers_token_block ON transfers (chain_id, token_address, block_number);
+CREATE INDEX IF NOT EXISTS transfers_from ON transfers (chain_id, from_address);
+CREATE INDEX IF NOT EXISTS transfers_to ON transfers (chain_id, to_address);
+CREATE INDEX IF NOT EXISTS transfers_block ON transfers (block_number);
+
+-- Running balance of every holder, always equal to the sum of its stored transfers. Mints and
+-- burns come from and go to the zero address, which is not tracked as a holder.
+CREATE TABLE IF NOT EXISTS token_balances (
+    chain_id      BIGINT NOT NULL,
+    token_address TEXT NOT NULL,
+    holder        TEXT NOT NULL,
+    balance       NUMERIC(78, 0) NOT NULL,
+    PRIMARY KEY (chain_id, token_address, holder)
+);
+
+CREATE INDEX IF NOT EXISTS token_balances_holder ON token_balances (chain_id, holder);
+
+-- Stored transfers move balances; deleted ones, such as those rolled back after a reorg, move them
+-- back. Rows skipped by ON CONFLICT DO NOTHING are not in the transition table, so rescanning a
+-- range never counts a transfer twice.
+CREATE OR REPLACE FUNCTION transfers_inserted() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO token_balances (chain_id, token_address, holder, balance)
+    SELECT chain_id, token_address, holder, SUM(delta)
+    FROM (
+        SELECT chain_id, token_address, to_address AS holder, value AS delta FROM new_transfers
+        UNION ALL
+        SELECT chain_id, token_address, from_address AS holder, -value AS delta FROM new_transfers
+    ) deltas
+    WHERE holder <> '0x0000000000000000000000000000000000000000'
+    GROUP BY chain_id, token_address, holder
+    ON CONFLICT (chain_id, token_address, holder) DO UPDATE SET balance = token_balances.balance + EXCLUDED.balance;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION transfers_deleted() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO token_balances (chain_id, token_address, holder, balance)
+    SELECT chain_id, token_address, holder, SUM(delta)
+    FROM (
+        SELECT chain_id, token_address, to_address AS holder, -value AS delta FROM old_transfers
+        UNION ALL
+        SELECT chain_id, token_address, from_address AS holder, value AS delta FROM old_transfers
+    ) deltas
+    WHERE holder <> '0x0000000000000000000000000000000000000000'
+    GROUP BY chain_id, token_address, holder
+    ON CONFLICT (chain_id, token_address, holder) DO UPDATE SET balance = token_balances.balance + EXCLUDED.balance;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transfers_insert_balances ON transfers;
+CREATE TRIGGER transfers_insert_balances
+    AFTER INSERT ON transfers
+    REFERENCING NEW TABLE AS new_transfers
+    FOR EACH STATEMENT EXECUTE PROCEDURE transfers_inserted();
+
+DROP TRIGGER IF EXISTS transfers_delete_balances ON transfers;
+CREATE TRIGGER transfers_delete_balances
+    AFTER DELETE ON transfers
+    REFERENCING OLD TABLE AS old_transfers
+    FOR EACH STATEMENT EXECUTE PROCEDURE transfers_deleted();
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS address_labels;
+DROP TABLE IF EXISTS spenders;
+DROP VIEW IF EXISTS current_allowances;
+DROP TABLE IF EXISTS approvals;
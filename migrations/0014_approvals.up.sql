@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS approvals (
+    chain_id        BIGINT NOT NULL,
+    token_address   TEXT NOT NULL,
+    block_number    BIGINT NOT NULL,
+    block_hash      TEXT NOT NULL,
+    block_timestamp TIMESTAMPTZ,
+    tx_hash         TEXT NOT NULL,
+    log_index       INTEGER NOT NULL,
+    owner_address   TEXT NOT NULL,
+    spender_address TEXT NOT NULL,
+    value           NUMERIC(78, 0) NOT NULL,
+    PRIMARY KEY (chain_id, tx_hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS approvals_owner ON approvals (chain_id, owner_address, token_address, spender_address, block_number DESC, log_index DESC);
+CREATE INDEX IF NOT EXISTS approvals_spender ON approvals (chain_id, spender_address);
+CREATE INDEX IF NOT EXISTS approvals_block ON approvals (block_number);
+
+-- The allowance set by the latest Approval of each owner, token and spender. Rolled back approvals
+-- are deleted, so the view is always derived from the canonical chain.
+CREATE OR REPLACE VIEW current_allowances AS
+SELECT DISTINCT ON (chain_id, owner_address, token_address, spender_address)
+    chain_id, owner_address, token_address, spender_address, value,
+    block_number, block_timestamp, tx_hash
+FROM approvals
+ORDER BY chain_id, owner_address, token_address, spender_address, block_number DESC, log_index DESC;
+
+-- Accounts that were given an allowance, with what token_monitor found out about them
+CREATE TABLE IF NOT EXISTS spenders (
+    chain_id         BIGINT NOT NULL,
+    address          TEXT NOT NULL,
+    first_seen_block BIGINT NOT NULL,
+    is_contract      BOOLEAN,
+    deployment_block BIGINT,
+    deployed_at      TIMESTAMPTZ,
+    checked_at       TIMESTAMPTZ,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT,
+    PRIMARY KEY (chain_id, address)
+);
+
+CREATE INDEX IF NOT EXISTS spenders_pending ON spenders (chain_id, first_seen_block) WHERE checked_at IS NULL;
+
+-- Names for well-known spenders, maintained by hand. Routers, factories and pools from the
+-- registry are labelled without an entry here.
+CREATE TABLE IF NOT EXISTS address_labels (
+    chain_id BIGINT NOT NULL,
+    address  TEXT NOT NULL,
+    label    TEXT NOT NULL,
+    PRIMARY KEY (chain_id, address)
+);
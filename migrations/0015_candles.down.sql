@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS scan_checkpoints_rewinds ON scan_checkpoints;
+DROP FUNCTION IF EXISTS checkpoint_rewound();
+DROP TABLE IF EXISTS checkpoint_rewinds;
+DROP TABLE IF EXISTS candles;
@@ -0,0 +1,56 @@
+-- OHLCV bars per pool and period, built from swaps by candle_builder. Prices are in the quote
+-- token per base token and in USD; volumes are decimal-adjusted. The open bar of each period has
+-- closed = false and is updated as swaps arrive.
+CREATE TABLE IF NOT EXISTS candles (
+    chain_id     BIGINT NOT NULL,
+    pool_address TEXT NOT NULL,
+    period       TEXT NOT NULL CHECK (period IN ('30s', '1m', '5m', '10m', '30m', '1h', '1d')),
+    bucket_start TIMESTAMPTZ NOT NULL,
+    bucket_end   TIMESTAMPTZ NOT NULL,
+    base_token   TEXT NOT NULL,
+    quote_token  TEXT NOT NULL,
+    open         DOUBLE PRECISION NOT NULL,
+    high         DOUBLE PRECISION NOT NULL,
+    low          DOUBLE PRECISION NOT NULL,
+    close        DOUBLE PRECISION NOT NULL,
+    open_usd     DOUBLE PRECISION, -- NULL while the quote token has no USD price
+    high_usd     DOUBLE PRECISION,
+    low_usd      DOUBLE PRECISION,
+    close_usd    DOUBLE PRECISION,
+    volume_base  DOUBLE PRECISION NOT NULL,
+    volume_quote DOUBLE PRECISION NOT NULL,
+    volume_usd   DOUBLE PRECISION,
+    trades       INTEGER NOT NULL,
+    first_block  BIGINT NOT NULL,
+    last_block   BIGINT NOT NULL,
+    closed       BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (chain_id, pool_address, period, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS candles_period_bucket ON candles (chain_id, period, bucket_start);
+CREATE INDEX IF NOT EXISTS candles_open ON candles (bucket_end) WHERE NOT closed;
+
+-- Checkpoints that moved back, such as after a reorg rollback or a -rewind, with the first block
+-- to process again. Bars merge swaps as they come and cannot take one back out, so candle_builder
+-- rebuilds its bars when the swaps scanner is rewound.
+CREATE TABLE IF NOT EXISTS checkpoint_rewinds (
+    scanner      TEXT NOT NULL,
+    block_number BIGINT NOT NULL,
+    recorded_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE OR REPLACE FUNCTION checkpoint_rewound() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO checkpoint_rewinds (scanner, block_number) VALUES (OLD.scanner, 0);
+    ELSIF NEW.last_block < OLD.last_block THEN
+        INSERT INTO checkpoint_rewinds (scanner, block_number) VALUES (NEW.scanner, NEW.last_block + 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS scan_checkpoints_rewinds ON scan_checkpoints;
+CREATE TRIGGER scan_checkpoints_rewinds
+    AFTER UPDATE OR DELETE ON scan_checkpoints
+    FOR EACH ROW EXECUTE PROCEDURE checkpoint_rewound();
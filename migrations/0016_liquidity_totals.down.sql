@@ -0,0 +1 @@
+DROP INDEX IF EXISTS liquidity_events_pool_kind;
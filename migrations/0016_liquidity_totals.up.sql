@@ -0,0 +1,3 @@
+-- Lets the rug-pull check sum a pool's mints or burns up to a given log with an index range scan
+-- instead of reading every event of the pool.
+CREATE INDEX IF NOT EXISTS liquidity_events_pool_kind ON liquidity_events (chain_id, pool_address, kind, block_number, log_index);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS pairs_chain_token1;
+DROP INDEX IF EXISTS pairs_chain_token0;
+DROP INDEX IF EXISTS pairs_block;
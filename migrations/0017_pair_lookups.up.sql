@@ -0,0 +1,4 @@
+-- Reorg rollbacks find the orphaned pools by block and check which tokens remaining pools still use.
+CREATE INDEX IF NOT EXISTS pairs_block ON pairs (block_number);
+CREATE INDEX IF NOT EXISTS pairs_chain_token0 ON pairs (chain_id, token0_address);
+CREATE INDEX IF NOT EXISTS pairs_chain_token1 ON pairs (chain_id, token1_address);
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS scan_checkpoints_rewinds ON scan_checkpoints;
+CREATE TRIGGER scan_checkpoints_rewinds
+    AFTER UPDATE OR DELETE ON scan_checkpoints
+    FOR EACH ROW EXECUTE PROCEDURE checkpoint_rewound();
@@ -0,0 +1,9 @@
+-- Only candle_builder reads checkpoint_rewinds, and only for the swaps scanner, so rewinds of other
+-- checkpoints are no longer recorded. The swaps group checkpoints as <monitor>:swaps.
+DROP TRIGGER IF EXISTS scan_checkpoints_rewinds ON scan_checkpoints;
+CREATE TRIGGER scan_checkpoints_rewinds
+    AFTER UPDATE OR DELETE ON scan_checkpoints
+    FOR EACH ROW WHEN (OLD.scanner LIKE '%:swaps')
+    EXECUTE PROCEDURE checkpoint_rewound();
+
+DELETE FROM checkpoint_rewinds WHERE scanner NOT LIKE '%:swaps';
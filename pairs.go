@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	chainID     int64          // Chain the pools are indexed from, recorded with every row
+	pairsClient rpcBatchCaller // Used to look up block timestamps and transaction senders
+
+	blockTimesMu sync.Mutex
+	blockTimes   = make(map[uint64]time.Time) // Timestamps of recently seen blocks
+
+	sendersMu sync.Mutex
+	senders   = make(map[common.Hash]common.Address) // Senders of recently seen transactions
+)
+
+const (
+	maxCachedBlockTimes = 4096
+	maxCachedSenders    = 4096
+)
+
+// initPairs records the chain being indexed. Rows from before chain ids were recorded
+// come from the same chain and get its id.
+func initPairs(client *RPCPool) {
+	id, err := client.ChainID(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to get the chain id: %v", err)
+	}
+	chainID = id.Int64()
+	pairsClient = client
+
+	if _, err := db.Exec(`UPDATE pairs SET chain_id = $1 WHERE chain_id IS NULL`, chainID); err != nil {
+		log.Fatalf("Failed to update the pairs table: %v", err)
+	}
+}
+
+// blockTimestamp returns the time a block was produced, from the cache when possible.
+func blockTimestamp(blockNumber uint64) (time.Time, error) {
+	blockTimesMu.Lock()
+	timestamp, ok := blockTimes[blockNumber]
+	blockTimesMu.Unlock()
+	if ok {
+		return timestamp, nil
+	}
+
+	if err := limiter.Wait(context.Background()); err != nil {
+		return time.Time{}, err
+	}
+	ref, err := fetchBlockRef(context.Background(), pairsClient, hexutil.EncodeUint64(blockNumber))
+	if err != nil {
+		return time.Time{}, err
+	}
+	timestamp = time.Unix(int64(ref.Timestamp), 0).UTC()
+
+	blockTimesMu.Lock()
+	if len(blockTimes) >= maxCachedBlockTimes {
+		blockTimes = make(map[uint64]time.Time)
+	}
+	blockTimes[blockNumber] = timestamp
+	blockTimesMu.Unlock()
+	return timestamp, nil
+}
+
+// transactionSender returns the account that sent a transaction, from the cache when possible.
+// The sender is read from the node's JSON rather than recovered from the signature, so that
+// every transaction type on Base, including deposits, is covered.
+func transactionSender(txHash common.Hash) (common.Address, error) {
+	sendersMu.Lock()
+	sender, ok := senders[txHash]
+	sendersMu.Unlock()
+	if ok {
+		return sender, nil
+	}
+
+	var tx *struct {
+		From common.Address `json:"from"`
+	}
+	err := retryRPC(context.Background(), "eth_getTransactionByHash", func(ctx context.Context) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return noRetry(err)
+		}
+		return pairsClient.CallContext(ctx, &tx, "eth_getTransactionByHash", txHash)
+	})
+	if err != nil {
+		return common.Address{}, err
+	}
+	if tx == nil || tx.From == (common.Address{}) {
+		return common.Address{}, malformedResponse("eth_getTransactionByHash", "no sender for transaction %s", txHash.Hex())
+	}
+
+	sendersMu.Lock()
+	if len(senders) >= maxCachedSenders {
+		senders = make(map[common.Hash]common.Address)
+	}
+	senders[txHash] = tx.From
+	sendersMu.Unlock()
+	return tx.From, nil
+}
+
+// prefetchBlockTimes caches the timestamps of the blocks that emitted logs, in batches, so the rows
+// of a page do not each wait on their own eth_getBlockByNumber. Blocks the batch misses are looked
+// up one by one by blockTimestamp.
+func prefetchBlockTimes(logs []types.Log) {
+	var missing []uint64
+	blockTimesMu.Lock()
+	for _, vLog := range logs {
+		_, ok := blockTimes[vLog.BlockNumber]
+		if !ok && (len(missing) == 0 || missing[len(missing)-1] != vLog.BlockNumber) {
+			missing = append(missing, vLog.BlockNumber)
+		}
+	}
+	blockTimesMu.Unlock()
+	if len(missing) == 0 {
+		return
+	}
+
+	refs := make([]*blockRef, len(missing))
+	calls := make([]rpc.BatchElem, len(missing))
+	for i, number := range missing {
+		calls[i] = rpc.BatchElem{Method: "eth_getBlockByNumber", Args: []interface{}{hexutil.EncodeUint64(number), false}, Result: &refs[i]}
+	}
+	if err := batchCall(context.Background(), pairsClient, "eth_getBlockByNumber", calls); err != nil {
+		log.Printf("Failed to prefetch the timestamps of %d blocks: %v", len(missing), err)
+		return
+	}
+
+	blockTimesMu.Lock()
+	defer blockTimesMu.Unlock()
+	// Make room for the whole page, so none of its blocks is evicted before it is used
+	if len(blockTimes)+len(missing) > maxCachedBlockTimes {
+		blockTimes = make(map[uint64]time.Time)
+	}
+	for i, call := range calls {
+		if call.Error == nil && refs[i] != nil {
+			blockTimes[missing[i]] = time.Unix(int64(refs[i].Timestamp), 0).UTC()
+		}
+	}
+}
+
+// prefetchSenders caches the senders of the transactions that emitted logs, in batches, like
+// prefetchBlockTimes. Transactions the batch misses are looked up one by one by transactionSender.
+func prefetchSenders(logs []types.Log) {
+	var missing []common.Hash
+	sendersMu.Lock()
+	for _, vLog := range logs {
+		_, ok := senders[vLog.TxHash]
+		if !ok && (len(missing) == 0 || missing[len(missing)-1] != vLog.TxHash) {
+			missing = append(missing, vLog.TxHash)
+		}
+	}
+	sendersMu.Unlock()
+	if len(missing) == 0 {
+		return
+	}
+
+	txs := make([]*struct {
+		From common.Address `json:"from"`
+	}, len(missing))
+	calls := make([]rpc.BatchElem, len(missing))
+	for i, txHash := range missing {
+		calls[i] = rpc.BatchElem{Method: "eth_getTransactionByHash", Args: []interface{}{txHash}, Result: &txs[i]}
+	}
+	if err := batchCall(context.Background(), pairsClient, "eth_getTransactionByHash", calls); err != nil {
+		log.Printf("Failed to prefetch the senders of %d transactions: %v", len(missing), err)
+		return
+	}
+
+	sendersMu.Lock()
+	defer sendersMu.Unlock()
+	if len(senders)+len(missing) > maxCachedSenders {
+		senders = make(map[common.Hash]common.Address)
+	}
+	for i, call := range calls {
+		if call.Error == nil && txs[i] != nil && txs[i].From != (common.Address{}) {
+			senders[missing[i]] = txs[i].From
+		}
+	}
+}
+
+// pairsTable writes pools with an upsert, so storing a pool again refreshes its row and
+// overlapping backfills are safe.
+var pairsTable = &batchTable{
+	name: "pairs",
+	columns: []string{"chain_id", "pair_address", "token0_address", "token1_address", "deployer_address", "factory_address",
+		"pool_type", "stable", "fee", "tick_spacing",
+		"block_number", "block_hash", "block_timestamp", "tx_hash", "log_index", "event_signature"},
+	conflict: `
+        ON CONFLICT (chain_id, pair_address) DO UPDATE SET
+            token0_address   = EXCLUDED.token0_address,
+            token1_address   = EXCLUDED.token1_address,
+            deployer_address = COALESCE(EXCLUDED.deployer_address, pairs.deployer_address),
+            factory_address  = EXCLUDED.factory_address,
+            pool_type        = EXCLUDED.pool_type,
+            stable           = EXCLUDED.stable,
+            fee              = EXCLUDED.fee,
+            tick_spacing     = EXCLUDED.tick_spacing,
+            block_number     = EXCLUDED.block_number,
+            block_hash       = EXCLUDED.block_hash,
+            block_timestamp  = COALESCE(EXCLUDED.block_timestamp, pairs.block_timestamp),
+            tx_hash          = EXCLUDED.tx_hash,
+            log_index        = EXCLUDED.log_index,
+            event_signature  = EXCLUDED.event_signature
+    `,
+	key: []int{0, 1},
+}
+
+// insertPool queues a newly created pool together with where it came from: the chain, block,
+// transaction, log and event. The row is written when the scanner flushes rowWriter.
+func insertPool(record PoolRecord) {
+	// The deployer is the account that sent the creating transaction, not the factory
+	var deployerAddress sql.NullString
+	if sender, err := transactionSender(record.Log.TxHash); err != nil {
+		log.Printf("Failed to get the sender of transaction %s: %v", record.Log.TxHash.Hex(), err)
+	} else {
+		deployerAddress = sql.NullString{String: sender.Hex(), Valid: true}
+	}
+
+	// Only concentrated-liquidity pools have a fee tier and tick spacing
+	var fee, tickSpacing sql.NullInt64
+	if record.isConcentrated() {
+		fee = sql.NullInt64{Int64: int64(record.Fee), Valid: true}
+		tickSpacing = sql.NullInt64{Int64: int64(record.TickSpacing), Valid: true}
+	}
+
+	// A missing timestamp is stored as NULL rather than losing the pool
+	var timestamp sql.NullTime
+	if t, err := blockTimestamp(record.Log.BlockNumber); err != nil {
+		log.Printf("Failed to get the timestamp of block %d: %v", record.Log.BlockNumber, err)
+	} else {
+		timestamp = sql.NullTime{Time: t, Valid: true}
+	}
+
+	rowWriter.add(pairsTable, chainID, record.Pool.Hex(), record.Token0.Hex(), record.Token1.Hex(), deployerAddress, record.Factory.Hex(),
+		string(record.Type), record.Stable, fee, tickSpacing,
+		record.Log.BlockNumber, record.Log.BlockHash.Hex(), timestamp, record.Log.TxHash.Hex(), record.Log.Index, record.Event)
+
+	// The tokens' metadata is fetched in the background
+	queueToken(record.Token0, record.Log.BlockNumber)
+	queueToken(record.Token1, record.Log.BlockNumber)
+}
+
+// forgetOrphanedPoolRows re-derives the tokens and factory candidates first seen from fromBlock on
+// after a rollback removed pools: rows no remaining pool refers to are deleted, and the others move
+// to the first block of a remaining pool. Candidates that were already reviewed are kept.
+func forgetOrphanedPoolRows(fromBlock uint64) error {
+	statements := []string{`
+        DELETE FROM tokens t
+        WHERE t.chain_id = $1 AND t.first_seen_block >= $2
+          AND NOT EXISTS (SELECT 1 FROM pairs p WHERE p.chain_id = t.chain_id AND p.token0_address = t.address)
+          AND NOT EXISTS (SELECT 1 FROM pairs p WHERE p.chain_id = t.chain_id AND p.token1_address = t.address)
+    `, `
+        UPDATE tokens t SET first_seen_block = COALESCE((
+            SELECT min(p.block_number) FROM pairs p
+            WHERE p.chain_id = t.chain_id AND (p.token0_address = t.address OR p.token1_address = t.address)
+        ), t.first_seen_block)
+        WHERE t.chain_id = $1 AND t.first_seen_block >= $2
+    `, `
+        DELETE FROM factory_candidates c
+        WHERE c.chain_id = $1 AND c.first_seen_block >= $2 AND c.status = 'pending'
+          AND NOT EXISTS (SELECT 1 FROM pairs p WHERE p.chain_id = c.chain_id AND p.factory_address = c.address)
+    `, `
+        UPDATE factory_candidates c SET first_seen_block = COALESCE((
+            SELECT min(p.block_number) FROM pairs p WHERE p.chain_id = c.chain_id AND p.factory_address = c.address
+        ), c.first_seen_block)
+        WHERE c.chain_id = $1 AND c.first_seen_block >= $2
+    `}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement, chainID, fromBlock); err != nil {
+			return err
+		}
+	}
+	return nil
+}
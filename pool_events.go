@@ -0,0 +1,79 @@
+package main
+
+// The pool-creation events indexed by the monitors, decoded from their ABI definitions.
+// Supporting another DEX means registering its event here.
+func init() {
+	// Uniswap V2 and its forks
+	registerPoolDecoder("event PairCreated(address indexed token0, address indexed token1, address pair, uint256 allPairsLength)",
+		v2Pool("token0", "token1", "pair"))
+
+	// Uniswap V3 and its forks
+	registerPoolDecoder("event PoolCreated(address indexed token0, address indexed token1, uint24 indexed fee, int24 tickSpacing, address pool)",
+		concentratedPool("token0", "token1", "pool", "fee", "tickSpacing"))
+
+	// iZiSwap; pointDelta plays the role of tick spacing
+	registerPoolDecoder("event NewPool(address indexed tokenX, address indexed tokenY, uint24 indexed fee, uint24 pointDelta, address pool)",
+		concentratedPool("tokenX", "tokenY", "pool", "fee", "pointDelta"))
+
+	// Solidly forks (Velodrome V1 style)
+	registerPoolDecoder("event PairCreated(address indexed token0, address indexed token1, bool stable, address pair, uint256 allPairsLength)",
+		solidlyPool("token0", "token1", "pair", "stable"))
+
+	// Aerodrome
+	registerPoolDecoder("event PoolCreated(address indexed token0, address indexed token1, bool indexed stable, address pool, uint256 allPoolsLength)",
+		solidlyPool("token0", "token1", "pool", "stable"))
+}
+
+// v2Pool decodes a constant-product pair.
+func v2Pool(token0, token1, pair string) func(values map[string]interface{}) (PoolRecord, error) {
+	fields := poolFields(token0, token1, pair)
+	return func(values map[string]interface{}) (PoolRecord, error) {
+		record, err := fields(values)
+		record.Type = poolTypeV2
+		return record, err
+	}
+}
+
+// concentratedPool decodes a concentrated-liquidity pool together with its fee tier and tick spacing.
+func concentratedPool(token0, token1, pool, fee, tickSpacing string) func(values map[string]interface{}) (PoolRecord, error) {
+	fields := poolFields(token0, token1, pool)
+	return func(values map[string]interface{}) (PoolRecord, error) {
+		record, err := fields(values)
+		if err != nil {
+			return record, err
+		}
+		record.Type = poolTypeV3
+
+		feeValue, err := intValue(values, fee, 0, 1<<24-1)
+		if err != nil {
+			return record, err
+		}
+		spacing, err := intValue(values, tickSpacing, 1, 1<<23-1)
+		if err != nil {
+			return record, err
+		}
+		record.Fee, record.TickSpacing = uint32(feeValue), int32(spacing)
+		return record, nil
+	}
+}
+
+// solidlyPool decodes a Solidly pair, which is either stable or volatile.
+func solidlyPool(token0, token1, pair, stable string) func(values map[string]interface{}) (PoolRecord, error) {
+	fields := poolFields(token0, token1, pair)
+	return func(values map[string]interface{}) (PoolRecord, error) {
+		record, err := fields(values)
+		if err != nil {
+			return record, err
+		}
+		isStable, err := boolValue(values, stable)
+		if err != nil {
+			return record, err
+		}
+		record.Stable = isStable
+		record.Type = poolTypeSolidlyVolatile
+		if isStable {
+			record.Type = poolTypeSolidlyStable
+		}
+		return record, nil
+	}
+}
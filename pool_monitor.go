@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"golang.org/x/time/rate"
+)
+
+var limiter = rate.NewLimiter(rate.Limit(24), 1) // 24 requests per second
+
+const (
+	infuraURL = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
+
+	poolMonitorScanner = "pool_monitor" // Reorg guard name; each event group checkpoints as pool_monitor:<group>
+)
+
+// scanPoolRange indexes the events of the groups emitted by every known pool in [fromBlock, toBlock]
+// and checkpoints the groups in the same transaction.
+func scanPoolRange(client *RPCPool, pager *logPager, pools *contractSet, groups []*eventGroup, fromBlock, toBlock uint64) {
+	addresses, _ := pools.upTo(toBlock)
+	logs := fetchContractLogs(client, pager, addresses, eventTopics(groups), fromBlock, toBlock)
+	log.Printf("Processing blocks %d to %d: %d pools, found %d logs", fromBlock, toBlock, len(addresses), len(logs))
+
+	commitEventLogs(logs, groups, groupScanners(poolMonitorScanner, groups), fromBlock, toBlock)
+}
+
+func main() {
+	rewind := flag.Int64("rewind", -1, "rewind the checkpoints of the selected event groups so the scan restarts at this block")
+	follow := flag.Bool("follow", false, "keep indexing new blocks after the historical range is done")
+	confirmations := flag.Uint64("confirmations", 0, "only index blocks this many blocks behind the head")
+	headTag := flag.String("head", "latest", "block tag to treat as the head: latest, safe or finalized")
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	events := flag.String("events", "", "comma separated event groups to index; all groups when empty")
+	poolsScanner := flag.String("pools", "topic_monitor", "scanner that indexes the pools; events are indexed up to its checkpoint")
+	flag.Parse()
+
+	initDB() // Initialize the database
+
+	// "migrate up|down|status" only manages the schema; otherwise bring it up to date and scan
+	if flag.Arg(0) == "migrate" {
+		runMigrateCommand(flag.Args()[1:])
+		return
+	}
+	migrateUp()
+
+	groups := selectEventGroups(*events)
+	if *rewind >= 0 {
+		for _, group := range groups {
+			if err := rewindCheckpoint(groupScanner(poolMonitorScanner, group), uint64(*rewind)); err != nil {
+				log.Fatalf("Failed to rewind: %v", err)
+			}
+		}
+	}
+
+	log.Println("Starting script...")
+
+	client, err := dialRPCPool(rpcURLs(*rpcList))
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+	defer client.Close()
+
+	initPairs(client)
+
+	// Nothing happens in a pool before it is created
+	var startBlock uint64
+	if err := db.QueryRow(`SELECT COALESCE(MIN(block_number), 0) FROM pairs WHERE chain_id = $1`, chainID).Scan(&startBlock); err != nil {
+		log.Fatalf("Failed to find the first pool: %v", err)
+	}
+
+	monitor := &contractMonitor{
+		scanner:       poolMonitorScanner,
+		groups:        groups,
+		contracts:     &contractSet{table: "pairs", addressColumn: "pair_address", blockColumn: "block_number"},
+		poolsScanner:  *poolsScanner,
+		startBlock:    startBlock,
+		headTag:       *headTag,
+		confirmations: *confirmations,
+		scanRange:     scanPoolRange,
+	}
+	monitor.run(client, *follow)
+
+	log.Println("Script completed.")
+}
@@ -1,19 +1,17 @@
 package main
 
 import (
-	"encoding/hex"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math"
 	"math/big"
 	"net/http"
-	"strconv"
-
-	"golang.org/x/crypto/sha3"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/rpc"
 )
 
 const (
@@ -22,49 +20,64 @@ const (
 	WETHAddress     = "0x4200000000000000000000000000000000000006"
 )
 
-// functionSelector computes the first 4 bytes of the keccak256 hash
-// of the provided function signature, which represents its Method ID.
-func functionSelector(funcSignature string) string {
-	hasher := sha3.NewLegacyKeccak256()
-	hasher.Write([]byte(funcSignature))
-	return "0x" + fmt.Sprintf("%x", hasher.Sum(nil)[:4])
-}
-
-// ethCall executes a call to the Ethereum network using the provided data.
-func ethCall(client *rpc.Client, to common.Address, data string) (string, error) {
-	args := map[string]interface{}{
-		"to":   to.Hex(),
-		"data": data,
-	}
-
-	var res string
-	err := client.Call(&res, "eth_call", args, "latest")
-	return res, err
-}
-
 func main() {
-	client, err := rpc.Dial(infuraURL)
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	block := flag.Int64("block", -1, "price the pool at this block from the indexed reserves instead of current balances")
+	flag.Parse()
+
+	client, err := dialRPCPool(rpcURLs(*rpcList))
 	if err != nil {
 		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
 	}
+	defer client.Close()
+
+	initDB() // The pool type, and historical reserves, come from the indexed tables
 
 	// Fetch token addresses
-	token0Address := callContract(client, functionSelector("token0()"))
-	token1Address := callContract(client, functionSelector("token1()"))
+	token0Address, err := callContract(client, functionSelector("token0()"))
+	if err != nil {
+		log.Fatalf("Failed to get token0: %v", err)
+	}
+	token1Address, err := callContract(client, functionSelector("token1()"))
+	if err != nil {
+		log.Fatalf("Failed to get token1: %v", err)
+	}
 
 	// Make WETH the base token, reorder if necessary
 	baseTokenAddress, quoteTokenAddress := orderBaseQuoteTokens(token0Address, token1Address)
 
 	// Fetch symbols
-	baseTokenSymbol := getSymbol(client, baseTokenAddress)
-	quoteTokenSymbol := getSymbol(client, quoteTokenAddress)
+	baseTokenSymbol := symbolOrAddress(client, baseTokenAddress)
+	quoteTokenSymbol := symbolOrAddress(client, quoteTokenAddress)
 
-	// Fetch balances using the reordered base and quote addresses
-	baseTokenBalance := getBalanceWithAdjustments(client, baseTokenAddress)
-	quoteTokenBalance := getBalanceWithAdjustments(client, quoteTokenAddress)
+	var baseTokenBalance, quoteTokenBalance *big.Float
+	if *block >= 0 {
+		// Historical prices come from the reserves pool_monitor stored, not from archive calls
+		baseTokenBalance, quoteTokenBalance, err = indexedBalances(client, token0Address, baseTokenAddress, quoteTokenAddress, uint64(*block))
+		if err != nil {
+			log.Fatalf("Failed to get the reserves at block %d: %v", *block, err)
+		}
+	} else {
+		// Fetch balances using the reordered base and quote addresses
+		baseTokenBalance, err = getBalanceWithAdjustments(client, baseTokenAddress)
+		if err != nil {
+			log.Fatalf("Failed to get %s balance: %v", baseTokenSymbol, err)
+		}
+		quoteTokenBalance, err = getBalanceWithAdjustments(client, quoteTokenAddress)
+		if err != nil {
+			log.Fatalf("Failed to get %s balance: %v", quoteTokenSymbol, err)
+		}
+	}
+
+	// Solidly stable pools price along x^3*y+y^3*x=k instead of x*y=k
+	stable, err := isStablePool(client)
+	if err != nil {
+		log.Fatalf("Failed to get the pool curve: %v", err)
+	}
 
-	price := computePrice(baseTokenBalance, quoteTokenBalance)
+	price := computePrice(baseTokenBalance, quoteTokenBalance, stable)
 
+	fmt.Printf("Stable pool: %v\n", stable)
 	fmt.Printf("%s Balance: %s\n", baseTokenSymbol, baseTokenBalance.Text('f', 10))
 	fmt.Printf("%s Balance: %s\n", quoteTokenSymbol, quoteTokenBalance.Text('f', 10))
 	fmt.Printf("Price (%s/%s): %s\n", quoteTokenSymbol, baseTokenSymbol, price.Text('f', 10))
@@ -88,73 +101,158 @@ func orderBaseQuoteTokens(token0, token1 common.Address) (base, quote common.Add
 }
 
 // callContract fetches the address at the end of the execution result of the provided data.
-func callContract(client *rpc.Client, data string) common.Address {
+func callContract(client rpcCaller, data string) (common.Address, error) {
 	res, err := ethCall(client, common.HexToAddress(liquidityPoolSC), data)
 	if err != nil {
-		log.Fatalf("Failed to call contract: %v", err)
+		return common.Address{}, err
 	}
-	return common.HexToAddress(res[len(res)-40:])
+	if len(res) < 42 {
+		return common.Address{}, malformedResponse("eth_call", "result %q is too short for an address", res)
+	}
+	return common.HexToAddress(res[len(res)-40:]), nil
 }
 
-// getSymbol fetches the symbol of a token.
-func getSymbol(client *rpc.Client, tokenAddress common.Address) string {
-	data := functionSelector("symbol()")
-	res, err := ethCall(client, tokenAddress, data)
+// symbolOrAddress returns the token's symbol, or its address when the token has no usable symbol.
+func symbolOrAddress(client rpcCaller, tokenAddress common.Address) string {
+	symbol, err := getSymbol(client, tokenAddress)
+	if isRPCErrorKind(err, rpcReverted) || isRPCErrorKind(err, rpcMalformed) {
+		log.Printf("No symbol for %s: %v", tokenAddress.Hex(), err)
+		return tokenAddress.Hex()
+	}
 	if err != nil {
 		log.Fatalf("Failed to get symbol: %v", err)
 	}
+	return symbol
+}
 
-	// The returned value is a hex encoded string, so we need to convert it to ASCII
-	bytes, err := hex.DecodeString(res[2:])
-	if err != nil {
-		log.Fatalf("Failed to decode symbol: %v", err)
-	}
-	return string(bytes)
+// adjustBalance adjusts the raw balance of a token based on its decimal precision.
+func adjustBalance(balance *big.Int, decimals int) *big.Float {
+	multiplier := new(big.Float).SetFloat64(math.Pow10(decimals))
+	adjustedBalance := new(big.Float).SetInt(balance)
+	return new(big.Float).Quo(adjustedBalance, multiplier)
 }
 
-// getBalance fetches the balance of an account for a specific token.
-func getBalance(client *rpc.Client, tokenAddress, accountAddress common.Address) *big.Int {
-	data := functionSelector("balanceOf(address)") + hex.EncodeToString(common.LeftPadBytes(accountAddress.Bytes(), 32))
-	res, err := ethCall(client, tokenAddress, data)
-	if err != nil {
-		log.Fatalf("Failed to get balance: %v", err)
+// computePrice calculates the marginal price of token1 in terms of token0. Constant-product pools
+// price at the reserve ratio; Solidly stable pools price along the curve x^3*y+y^3*x=k, where
+// the price is (x^3 + 3xy^2) / (3x^2y + y^3).
+func computePrice(balance0, balance1 *big.Float, stable bool) *big.Float {
+	if !stable {
+		return new(big.Float).Quo(balance0, balance1)
 	}
 
-	balance := new(big.Int)
-	balance.SetString(res[2:], 16)
-	return balance
-}
+	x, y := balance0, balance1
+	xx := new(big.Float).Mul(x, x)
+	yy := new(big.Float).Mul(y, y)
 
-// getDecimals fetches the decimal precision of a token.
-func getDecimals(client *rpc.Client, tokenAddress common.Address) int {
-	data := functionSelector("decimals()")
-	res, err := ethCall(client, tokenAddress, data)
-	if err != nil {
-		log.Fatalf("Failed to get decimals: %v", err)
-	}
+	numerator := new(big.Float).Mul(xx, x)
+	numerator.Add(numerator, new(big.Float).Mul(big.NewFloat(3), new(big.Float).Mul(x, yy)))
+
+	denominator := new(big.Float).Mul(yy, y)
+	denominator.Add(denominator, new(big.Float).Mul(big.NewFloat(3), new(big.Float).Mul(xx, y)))
 
-	decimals, _ := strconv.ParseInt(res[2:], 16, 64)
-	return int(decimals)
+	return numerator.Quo(numerator, denominator)
 }
 
-// adjustBalance adjusts the raw balance of a token based on its decimal precision.
-func adjustBalance(balance *big.Int, decimals int) *big.Float {
-	multiplier := new(big.Float).SetFloat64(math.Pow10(decimals))
-	adjustedBalance := new(big.Float).SetInt(balance)
-	return new(big.Float).Quo(adjustedBalance, multiplier)
+// isStablePool reports whether the pool is a Solidly stable pool, from the pool_type topic_monitor
+// stored in pairs. Pools that have not been indexed yet are asked through stable().
+func isStablePool(client *RPCPool) (bool, error) {
+	chain, err := client.ChainID(context.Background())
+	if err != nil {
+		return false, err
+	}
+	var stable sql.NullBool
+	err = db.QueryRow(`SELECT pool_type = 'solidly-stable' FROM pairs WHERE chain_id = $1 AND pair_address = $2`,
+		chain.Int64(), common.HexToAddress(liquidityPoolSC).Hex()).Scan(&stable)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if stable.Valid {
+		return stable.Bool, nil
+	}
+	log.Printf("Pool %s has no stored pool type, asking it for stable()", liquidityPoolSC)
+	return callStable(client)
 }
 
-// computePrice calculates the price of token1 in terms of token0.
-func computePrice(balance0, balance1 *big.Float) *big.Float {
-	return new(big.Float).Quo(balance0, balance1)
+// callStable asks the pool for stable(). Pools without the getter, such as Uniswap V2 pairs,
+// revert and are treated as constant-product pools.
+func callStable(client rpcCaller) (bool, error) {
+	res, err := ethCall(client, common.HexToAddress(liquidityPoolSC), functionSelector("stable()"))
+	if isRPCErrorKind(err, rpcReverted) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(res) != 66 {
+		return false, malformedResponse("eth_call", "stable() returned %q, not one bool word", res)
+	}
+	value, ok := new(big.Int).SetString(res[2:], 16)
+	if !ok || value.Cmp(big.NewInt(1)) > 0 {
+		return false, malformedResponse("eth_call", "invalid stable() result %q", res)
+	}
+	return value.Sign() == 1, nil
 }
 
 // getBalanceWithAdjustments fetches the balance and adjusts it based on the decimals
-func getBalanceWithAdjustments(client *rpc.Client, tokenAddress common.Address) *big.Float {
+func getBalanceWithAdjustments(client rpcCaller, tokenAddress common.Address) (*big.Float, error) {
 	liquidityAddress := common.HexToAddress(liquidityPoolSC)
-	balanceRaw := getBalance(client, tokenAddress, liquidityAddress)
-	decimals := getDecimals(client, tokenAddress)
-	return adjustBalance(balanceRaw, decimals)
+	balanceRaw, err := getBalance(client, tokenAddress, liquidityAddress)
+	if err != nil {
+		return nil, err
+	}
+	decimals, err := getDecimals(client, tokenAddress)
+	if err != nil {
+		return nil, err
+	}
+	return adjustBalance(balanceRaw, decimals), nil
+}
+
+// indexedBalances returns the pool's reserves of the base and quote tokens after its last Sync at
+// or before block, as indexed by pool_monitor, adjusted for decimals.
+func indexedBalances(client *RPCPool, token0, base, quote common.Address, block uint64) (*big.Float, *big.Float, error) {
+	chain, err := client.ChainID(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+        SELECT reserve0, reserve1, block_number FROM reserves
+        WHERE chain_id = $1 AND pool_address = $2 AND block_number <= $3
+        ORDER BY block_number DESC
+        LIMIT 1
+    `
+	var reserve0, reserve1 string
+	var atBlock uint64
+	err = db.QueryRow(query, chain.Int64(), common.HexToAddress(liquidityPoolSC).Hex(), block).Scan(&reserve0, &reserve1, &atBlock)
+	if err == sql.ErrNoRows {
+		return nil, nil, fmt.Errorf("no reserves indexed for %s at or before block %d", liquidityPoolSC, block)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Using the reserves from block %d", atBlock)
+
+	baseRaw, ok := new(big.Int).SetString(reserve0, 10)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid reserve %q", reserve0)
+	}
+	quoteRaw, ok := new(big.Int).SetString(reserve1, 10)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid reserve %q", reserve1)
+	}
+	if base != token0 {
+		baseRaw, quoteRaw = quoteRaw, baseRaw
+	}
+
+	baseDecimals, err := getDecimals(client, base)
+	if err != nil {
+		return nil, nil, err
+	}
+	quoteDecimals, err := getDecimals(client, quote)
+	if err != nil {
+		return nil, nil, err
+	}
+	return adjustBalance(baseRaw, baseDecimals), adjustBalance(quoteRaw, quoteDecimals), nil
 }
 
 func getWETHPriceUSD() float64 {
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// quarantineLog stores a log that failed validation, with the reason, so it can be inspected
+// later instead of crashing the run or being stored with wrong values.
+func quarantineLog(vLog types.Log, reason error) {
+	log.Printf("Quarantining log %s:%d from %s: %v", vLog.TxHash.Hex(), vLog.Index, vLog.Address.Hex(), reason)
+
+	topics := make([]string, len(vLog.Topics))
+	for i, topic := range vLog.Topics {
+		topics[i] = topic.Hex()
+	}
+	query := `
+        INSERT INTO quarantined_logs (tx_hash, log_index, block_number, block_hash, address, topics, data, reason)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (tx_hash, log_index) DO UPDATE SET reason = EXCLUDED.reason, quarantined_at = now()
+    `
+	_, err := db.Exec(query, vLog.TxHash.Hex(), vLog.Index, vLog.BlockNumber, vLog.BlockHash.Hex(), vLog.Address.Hex(),
+		strings.Join(topics, ","), hexutil.Encode(vLog.Data), reason.Error())
+	if err != nil {
+		log.Printf("Failed to quarantine log: %v", err)
+	}
+}
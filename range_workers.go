@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const rangesAheadPerWorker = 4 // How far workers may run ahead of the oldest uncommitted range
+
+// fetchedRange is a block range together with the logs a worker fetched for it.
+type fetchedRange struct {
+	seq       int
+	fromBlock uint64
+	toBlock   uint64
+	logs      []types.Log
+	err       error
+}
+
+// scanConcurrently fetches [startBlock, endBlock] in chunks of chunkSize blocks on several workers,
+// all sharing the rate limiter, and calls commit for each chunk strictly in block order. Whatever
+// commit records therefore always covers a contiguous prefix of the range. The first chunk that
+// fails to fetch stops the scan, and its error is returned once the chunks before it are committed.
+func scanConcurrently(ctx context.Context, client logFetcher, query ethereum.FilterQuery, startBlock, endBlock, chunkSize uint64, workers int, commit func(fromBlock, toBlock uint64, logs []types.Log)) error {
+	if startBlock > endBlock {
+		return nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan fetchedRange)
+	results := make(chan fetchedRange, workers)
+	window := make(chan struct{}, workers*rangesAheadPerWorker) // Bounds the number of uncommitted ranges
+
+	// Hand out the chunks in order
+	go func() {
+		defer close(jobs)
+		seq := 0
+		for fromBlock := startBlock; fromBlock <= endBlock; fromBlock += chunkSize {
+			toBlock := fromBlock + chunkSize - 1
+			if toBlock > endBlock || toBlock < fromBlock {
+				toBlock = endBlock
+			}
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- fetchedRange{seq: seq, fromBlock: fromBlock, toBlock: toBlock}:
+			case <-ctx.Done():
+				return
+			}
+			seq++
+			if toBlock == endBlock {
+				return
+			}
+		}
+	}()
+
+	// Each worker has its own pager, so a busy chunk only shrinks the pages of the worker fetching it
+	for i := 0; i < workers; i++ {
+		go func() {
+			pager := newLogPager()
+			for job := range jobs {
+				job.logs, job.err = pager.collectLogs(ctx, client, query, job.fromBlock, job.toBlock)
+				select {
+				case results <- job:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// Commit finished chunks in order, holding back any that arrive early
+	pending := make(map[int]fetchedRange)
+	for next := 0; ; {
+		if done, ok := pending[next]; ok {
+			delete(pending, next)
+			if done.err != nil {
+				return fmt.Errorf("failed to filter logs for blocks %d to %d: %w", done.fromBlock, done.toBlock, done.err)
+			}
+			commit(done.fromBlock, done.toBlock, done.logs)
+			<-window
+			next++
+			if done.toBlock == endBlock {
+				return nil
+			}
+			continue
+		}
+
+		select {
+		case result := <-results:
+			pending[result.seq] = result
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeChunkFetcher returns one log at the first block of every range it is asked for. The range
+// starting at slowBlock is answered last, once every other range has been fetched, and the one
+// starting at failBlock fails.
+type fakeChunkFetcher struct {
+	chunks    int
+	slowBlock uint64
+	failBlock uint64
+
+	mu      sync.Mutex
+	fetched int
+	release chan struct{}
+}
+
+func (f *fakeChunkFetcher) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	fromBlock := query.FromBlock.Uint64()
+	if fromBlock == f.slowBlock {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	f.fetched++
+	if f.fetched == f.chunks-1 {
+		close(f.release)
+	}
+	f.mu.Unlock()
+
+	if fromBlock == f.failBlock {
+		return nil, codeError{3, "execution reverted"}
+	}
+	return []types.Log{{BlockNumber: fromBlock, Topics: []common.Hash{}}}, nil
+}
+
+func TestScanConcurrentlyCommitsInOrder(t *testing.T) {
+	fetcher := &fakeChunkFetcher{chunks: 6, slowBlock: 100, failBlock: 1 << 63, release: make(chan struct{})}
+	var commits []string
+	commit := func(fromBlock, toBlock uint64, logs []types.Log) {
+		commits = append(commits, fmt.Sprintf("%d-%d:%d", fromBlock, toBlock, len(logs)))
+	}
+
+	// The first chunk arrives last, so every other one must wait for it
+	if err := scanConcurrently(context.Background(), fetcher, ethereum.FilterQuery{}, 100, 650, 100, 6, commit); err != nil {
+		t.Fatalf("scanConcurrently failed: %v", err)
+	}
+	want := "[100-199:1 200-299:1 300-399:1 400-499:1 500-599:1 600-650:1]"
+	if fmt.Sprint(commits) != want {
+		t.Errorf("committed %v, want %s", commits, want)
+	}
+}
+
+func TestScanConcurrentlyStopsAtFailure(t *testing.T) {
+	fetcher := &fakeChunkFetcher{chunks: 5, slowBlock: 100, failBlock: 300, release: make(chan struct{})}
+	var commits []uint64
+	commit := func(fromBlock, toBlock uint64, logs []types.Log) {
+		commits = append(commits, fromBlock)
+	}
+
+	// The chunks after the failed one are fetched, but never committed
+	err := scanConcurrently(context.Background(), fetcher, ethereum.FilterQuery{}, 100, 599, 100, 5, commit)
+	if !isRPCErrorKind(err, rpcReverted) {
+		t.Errorf("scanConcurrently = %v, want the failed chunk's error", err)
+	}
+	if fmt.Sprint(commits) != "[100 200]" {
+		t.Errorf("committed the chunks from %v, want only the two before the failure", commits)
+	}
+}
+
+func TestScanConcurrentlyEmptyRange(t *testing.T) {
+	commit := func(fromBlock, toBlock uint64, logs []types.Log) {
+		t.Errorf("committed %d to %d of an empty range", fromBlock, toBlock)
+	}
+	if err := scanConcurrently(context.Background(), nil, ethereum.FilterQuery{}, 10, 9, 100, 4, commit); err != nil {
+		t.Errorf("scanConcurrently of an empty range = %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const reorgWindow = 256 // Number of recent block hashes kept per scanner
+
+var errReorgDetected = errors.New("parent hash does not match the indexed chain")
+
+// blockRef is the part of a block header needed to follow the canonical chain. It is read from
+// the raw JSON response, because Base headers carry fields this go-ethereum version cannot hash.
+type blockRef struct {
+	Number     hexutil.Uint64 `json:"number"`
+	Hash       common.Hash    `json:"hash"`
+	ParentHash common.Hash    `json:"parentHash"`
+	Timestamp  hexutil.Uint64 `json:"timestamp"`
+}
+
+// fetchBlockRef returns the header of a block given as a number or a tag such as "latest", "safe" or "finalized".
+func fetchBlockRef(ctx context.Context, client rpcCaller, block string) (*blockRef, error) {
+	var ref *blockRef
+	err := retryRPC(ctx, "eth_getBlockByNumber", func(ctx context.Context) error {
+		return client.CallContext(ctx, &ref, "eth_getBlockByNumber", block, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return nil, fmt.Errorf("block %s not found", block)
+	}
+	return ref, nil
+}
+
+// fetchBlockRefs returns the headers of the given blocks, fetched in batches under the limiter.
+func fetchBlockRefs(ctx context.Context, client rpcBatchCaller, numbers []uint64) ([]*blockRef, error) {
+	refs := make([]*blockRef, len(numbers))
+	calls := make([]rpc.BatchElem, len(numbers))
+	for i, number := range numbers {
+		calls[i] = rpc.BatchElem{Method: "eth_getBlockByNumber", Args: []interface{}{hexutil.EncodeUint64(number), false}, Result: &refs[i]}
+	}
+	if err := batchCall(ctx, client, "eth_getBlockByNumber", calls); err != nil {
+		return nil, err
+	}
+	for i, call := range calls {
+		if call.Error != nil {
+			return nil, &RPCError{Kind: classifyRPCError(call.Error), Method: "eth_getBlockByNumber", Err: call.Error}
+		}
+		if refs[i] == nil {
+			return nil, fmt.Errorf("block %d not found", numbers[i])
+		}
+	}
+	return refs, nil
+}
+
+// confirmedHead returns the newest block that is safe to index: the block behind headTag,
+// minus the configured number of confirmations.
+func confirmedHead(ctx context.Context, client rpcCaller, headTag string, confirmations uint64) (uint64, error) {
+	ref, err := fetchBlockRef(ctx, client, headTag)
+	if err != nil {
+		return 0, err
+	}
+	head := uint64(ref.Number)
+	if head < confirmations {
+		return 0, nil
+	}
+	return head - confirmations, nil
+}
+
+// reorgGuard records the hashes of indexed blocks for one scanner and rolls back the rows it
+// wrote from blocks that are no longer part of the canonical chain.
+type reorgGuard struct {
+	scanner    string
+	client     rpcBatchCaller
+	tables     []string               // Tables the scanner writes; each has block_number and block_hash columns
+	checkpoint func(lastBlock uint64) // Moves the scanner's checkpoint back after a rollback
+}
+
+// storedHash returns the recorded hash of a block, if there is one.
+func (g *reorgGuard) storedHash(blockNumber uint64) (common.Hash, bool) {
+	var hash string
+	err := db.QueryRow(`SELECT block_hash FROM indexed_blocks WHERE scanner = $1 AND block_number = $2`, g.scanner, blockNumber).Scan(&hash)
+	if err != nil {
+		return common.Hash{}, false
+	}
+	return common.HexToHash(hash), true
+}
+
+// record stores the hashes of [fromBlock, toBlock] after checking that each block builds on the previous one.
+// Only the last reorgWindow blocks of a large range are recorded.
+func (g *reorgGuard) record(ctx context.Context, fromBlock, toBlock uint64) error {
+	if toBlock-fromBlock+1 > reorgWindow {
+		fromBlock = toBlock - reorgWindow + 1
+	}
+
+	numbers := make([]uint64, 0, toBlock-fromBlock+1)
+	for blockNumber := fromBlock; blockNumber <= toBlock; blockNumber++ {
+		numbers = append(numbers, blockNumber)
+	}
+	refs, err := fetchBlockRefs(ctx, g.client, numbers)
+	if err != nil {
+		return err
+	}
+
+	previous, havePrevious := g.storedHash(fromBlock - 1)
+	for _, ref := range refs {
+		if havePrevious && ref.ParentHash != previous {
+			log.Printf("Block %d does not build on the indexed block %d", uint64(ref.Number), uint64(ref.Number)-1)
+			return errReorgDetected
+		}
+		previous, havePrevious = ref.Hash, true
+	}
+
+	for _, ref := range refs {
+		query := `
+            INSERT INTO indexed_blocks (scanner, block_number, block_hash, parent_hash)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (scanner, block_number) DO UPDATE SET block_hash = EXCLUDED.block_hash, parent_hash = EXCLUDED.parent_hash
+        `
+		if _, err := db.Exec(query, g.scanner, uint64(ref.Number), ref.Hash.Hex(), ref.ParentHash.Hex()); err != nil {
+			return err
+		}
+	}
+
+	if toBlock > reorgWindow {
+		if _, err := db.Exec(`DELETE FROM indexed_blocks WHERE scanner = $1 AND block_number < $2`, g.scanner, toBlock-reorgWindow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordIndexed records the last block indexed before following starts, so the first followed
+// block can be checked against it.
+func (g *reorgGuard) recordIndexed(ctx context.Context, lastBlock uint64) {
+	if err := g.record(ctx, lastBlock, lastBlock); err != nil && err != errReorgDetected {
+		log.Fatalf("Failed to record block %d: %v", lastBlock, err)
+	}
+}
+
+// verify checks that the blocks indexed before nextBlock are still canonical. After a reorg it
+// rolls back to the last common ancestor and returns the block the scanner must continue from.
+func (g *reorgGuard) verify(ctx context.Context, nextBlock uint64) (uint64, error) {
+	rows, err := db.Query(`
+        SELECT block_number, block_hash FROM indexed_blocks
+        WHERE scanner = $1 AND block_number < $2
+        ORDER BY block_number DESC
+    `, g.scanner, nextBlock)
+	if err != nil {
+		return nextBlock, err
+	}
+	type stored struct {
+		number uint64
+		hash   string
+	}
+	var recent []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.number, &s.hash); err != nil {
+			rows.Close()
+			return nextBlock, err
+		}
+		recent = append(recent, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nextBlock, err
+	}
+
+	// Walk back until a recorded block is still on the canonical chain
+	for i, s := range recent {
+		if err := limiter.Wait(ctx); err != nil {
+			return nextBlock, err
+		}
+		ref, err := fetchBlockRef(ctx, g.client, hexutil.EncodeUint64(s.number))
+		if err != nil {
+			return nextBlock, err
+		}
+		if ref.Hash == common.HexToHash(s.hash) {
+			if i == 0 {
+				return nextBlock, nil
+			}
+			log.Printf("Reorg detected for %s: common ancestor is block %d", g.scanner, s.number)
+			if err := g.rollback(ctx, s.number+1, nextBlock-1); err != nil {
+				return nextBlock, err
+			}
+			return s.number + 1, nil
+		}
+	}
+
+	if len(recent) > 0 {
+		return nextBlock, fmt.Errorf("reorg deeper than the %d recorded blocks of %s", len(recent), g.scanner)
+	}
+	return nextBlock, nil
+}
+
+// rollback removes rows recorded in [fromBlock, toBlock] whose block hash is no longer canonical,
+// forgets the recorded hashes and moves the checkpoint back to fromBlock-1. Only the blocks that
+// left rows are looked up. Pools removed from pairs also take the tokens and factory candidates
+// first seen through them.
+func (g *reorgGuard) rollback(ctx context.Context, fromBlock, toBlock uint64) error {
+	numbers, err := g.blocksWithRows(fromBlock, toBlock)
+	if err != nil {
+		return err
+	}
+	refs, err := fetchBlockRefs(ctx, g.client, numbers)
+	if err != nil {
+		return err
+	}
+
+	var removedPools bool
+	for _, ref := range refs {
+		for _, table := range g.tables {
+			result, err := db.Exec(`DELETE FROM `+table+` WHERE block_number = $1 AND block_hash <> $2`, uint64(ref.Number), ref.Hash.Hex())
+			if err != nil {
+				return err
+			}
+			if removed, _ := result.RowsAffected(); removed > 0 {
+				log.Printf("Removed %d rows of %s from orphaned block %d", removed, table, uint64(ref.Number))
+				removedPools = removedPools || table == "pairs"
+			}
+		}
+	}
+	if removedPools {
+		if err := forgetOrphanedPoolRows(fromBlock); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.Exec(`DELETE FROM indexed_blocks WHERE scanner = $1 AND block_number >= $2`, g.scanner, fromBlock); err != nil {
+		return err
+	}
+	if fromBlock > 0 {
+		g.checkpoint(fromBlock - 1)
+	}
+	return nil
+}
+
+// blocksWithRows returns the blocks in [fromBlock, toBlock] that rows of the guard's tables come from, in order.
+func (g *reorgGuard) blocksWithRows(fromBlock, toBlock uint64) ([]uint64, error) {
+	var selects []string
+	for _, table := range g.tables {
+		selects = append(selects, `SELECT block_number FROM `+table+` WHERE block_number BETWEEN $1 AND $2`)
+	}
+	if len(selects) == 0 {
+		return nil, nil
+	}
+	rows, err := db.Query(strings.Join(selects, " UNION ")+` ORDER BY 1`, fromBlock, toBlock)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var numbers []uint64
+	for rows.Next() {
+		var number uint64
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, rows.Err()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeChain answers eth_getBlockByNumber from a canonical chain whose block n has canonicalHash(n),
+// one call at a time or in batches.
+type fakeChain struct {
+	head uint64
+
+	mu      sync.Mutex
+	fetched []uint64 // Every block asked for, in order
+	batches int
+}
+
+func canonicalHash(n uint64) common.Hash { return common.BigToHash(new(big.Int).SetUint64(n + 1)) }
+func orphanedHash(n uint64) common.Hash  { return common.BigToHash(new(big.Int).SetUint64(n + 1<<32)) }
+
+func (c *fakeChain) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if method != "eth_getBlockByNumber" {
+		return fmt.Errorf("unexpected call to %s", method)
+	}
+	number, err := hexutil.DecodeUint64(args[0].(string))
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.fetched = append(c.fetched, number)
+	c.mu.Unlock()
+
+	var ref *blockRef
+	if number <= c.head {
+		ref = &blockRef{Number: hexutil.Uint64(number), Hash: canonicalHash(number), ParentHash: canonicalHash(number - 1)}
+	}
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (c *fakeChain) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	c.mu.Lock()
+	c.batches++
+	c.mu.Unlock()
+	for i := range b {
+		b[i].Error = c.CallContext(ctx, b[i].Result, b[i].Method, b[i].Args...)
+	}
+	return nil
+}
+
+func TestReorgGuardRecordChecksParents(t *testing.T) {
+	stored := map[uint64]common.Hash{99: canonicalHash(99)}
+	fake := useFakeDB(t)
+	fake.query = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if hash, ok := stored[uint64(args[1].(int64))]; ok {
+			return []string{"block_hash"}, [][]driver.Value{{hash.Hex()}}, nil
+		}
+		return []string{"block_hash"}, nil, nil
+	}
+	chain := &fakeChain{head: 200}
+	guard := &reorgGuard{scanner: "topic_monitor", client: chain, tables: []string{"pairs"}}
+
+	if err := guard.record(context.Background(), 100, 149); err != nil {
+		t.Fatalf("record of blocks building on the stored block 99 = %v", err)
+	}
+	if chain.batches != 1 || len(chain.fetched) != 50 {
+		t.Errorf("record fetched %d blocks in %d batches, want 50 in 1", len(chain.fetched), chain.batches)
+	}
+	var inserted int
+	for _, statement := range fake.executed() {
+		if strings.Contains(statement.query, "INSERT INTO indexed_blocks") {
+			inserted++
+		}
+	}
+	if inserted != 50 {
+		t.Errorf("record stored %d hashes, want 50", inserted)
+	}
+
+	// The indexed block 149 was orphaned; the canonical block 150 does not build on it
+	stored[149] = orphanedHash(149)
+	if err := guard.record(context.Background(), 150, 160); err != errReorgDetected {
+		t.Errorf("record on top of an orphaned block = %v, want errReorgDetected", err)
+	}
+}
+
+func TestReorgGuardRollback(t *testing.T) {
+	type row struct {
+		table string
+		block uint64
+		hash  common.Hash
+	}
+	rows := []row{
+		{"pairs", 100, canonicalHash(100)},
+		{"pairs", 101, orphanedHash(101)},
+		{"pairs", 101, canonicalHash(101)}, // Written again after the reorg
+		{"swaps", 103, orphanedHash(103)},
+		{"pairs", 110, canonicalHash(110)},
+	}
+
+	fake := useFakeDB(t)
+	fake.query = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		seen := make(map[uint64]bool)
+		var blocks [][]driver.Value
+		for _, r := range rows {
+			if r.block >= uint64(args[0].(int64)) && r.block <= uint64(args[1].(int64)) && !seen[r.block] {
+				seen[r.block] = true
+				blocks = append(blocks, []driver.Value{int64(r.block)})
+			}
+		}
+		return []string{"block_number"}, blocks, nil
+	}
+	fake.exec = func(query string, args []driver.Value) (int64, error) {
+		var removed int64
+		for _, table := range []string{"pairs", "swaps"} {
+			if !strings.HasPrefix(query, "DELETE FROM "+table+" ") {
+				continue
+			}
+			kept := rows[:0]
+			for _, r := range rows {
+				if r.table == table && r.block == uint64(args[0].(int64)) && r.hash.Hex() != args[1] {
+					removed++
+				} else {
+					kept = append(kept, r)
+				}
+			}
+			rows = kept
+		}
+		return removed, nil
+	}
+
+	chain := &fakeChain{head: 200}
+	var checkpoints []uint64
+	guard := &reorgGuard{scanner: "pool_monitor", client: chain, tables: []string{"pairs", "swaps"},
+		checkpoint: func(lastBlock uint64) { checkpoints = append(checkpoints, lastBlock) }}
+	if err := guard.rollback(context.Background(), 101, 109); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+
+	// Only the blocks that left rows are looked up, in one batch
+	if fmt.Sprint(chain.fetched) != "[101 103]" || chain.batches != 1 {
+		t.Errorf("rollback fetched blocks %v in %d batches, want [101 103] in 1", chain.fetched, chain.batches)
+	}
+	want := []row{{"pairs", 100, canonicalHash(100)}, {"pairs", 101, canonicalHash(101)}, {"pairs", 110, canonicalHash(110)}}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("rows left after the rollback = %v, want %v", rows, want)
+	}
+
+	var rederived, forgotten bool
+	for _, statement := range fake.executed() {
+		if strings.Contains(statement.query, "tokens t") || strings.Contains(statement.query, "factory_candidates c") {
+			rederived = true
+			if statement.args[1] != int64(101) {
+				t.Errorf("derived rows re-derived from block %v, want 101", statement.args[1])
+			}
+		}
+		if strings.Contains(statement.query, "DELETE FROM indexed_blocks") {
+			forgotten = statement.args[0] == "pool_monitor" && statement.args[1] == int64(101)
+		}
+	}
+	if !rederived {
+		t.Error("rollback removed a pool but left its tokens and factory candidates")
+	}
+	if !forgotten {
+		t.Error("rollback kept the recorded hashes from block 101 on")
+	}
+	if fmt.Sprint(checkpoints) != "[100]" {
+		t.Errorf("rollback checkpointed %v, want [100]", checkpoints)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "github.com/ethereum/go-ethereum/core/types"
+
+// The Sync events constant-product pools emit with their reserves after every change.
+func init() {
+	reserves := registerEventGroup("reserves", "reserves")
+
+	// Uniswap V2 and its forks
+	reserves.register("event Sync(uint112 reserve0, uint112 reserve1)", syncReserves)
+
+	// Solidly forks and Aerodrome
+	reserves.register("event Sync(uint256 reserve0, uint256 reserve1)", syncReserves)
+}
+
+// reservesTable keeps one row per pool and block. Logs are added in order, so the buffered row of
+// a block is replaced until it holds the reserves after the block's last Sync.
+var reservesTable = &batchTable{
+	name: "reserves",
+	columns: []string{"chain_id", "pool_address", "block_number", "block_hash", "block_timestamp", "tx_hash", "log_index",
+		"reserve0", "reserve1"},
+	conflict: `
+        ON CONFLICT (chain_id, pool_address, block_number) DO UPDATE SET
+            block_hash      = EXCLUDED.block_hash,
+            block_timestamp = COALESCE(EXCLUDED.block_timestamp, reserves.block_timestamp),
+            tx_hash         = EXCLUDED.tx_hash,
+            log_index       = EXCLUDED.log_index,
+            reserve0        = EXCLUDED.reserve0,
+            reserve1        = EXCLUDED.reserve1
+    `,
+	key: []int{0, 1, 2},
+}
+
+// syncReserves queues the reserves reported by a Sync event.
+func syncReserves(vLog types.Log, values map[string]interface{}) error {
+	reserve0, err := bigValue(values, "reserve0")
+	if err != nil {
+		return err
+	}
+	reserve1, err := bigValue(values, "reserve1")
+	if err != nil {
+		return err
+	}
+
+	rowWriter.add(reservesTable, chainID, vLog.Address.Hex(), vLog.BlockNumber, vLog.BlockHash.Hex(), logTimestamp(vLog),
+		vLog.TxHash.Hex(), vLog.Index, reserve0.String(), reserve1.String())
+	return nil
+}
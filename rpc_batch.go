@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const rpcBatchSize = 100 // Calls sent in one JSON-RPC batch
+
+// batchCall sends calls in JSON-RPC batches of rpcBatchSize, waiting on the limiter for every call
+// in a batch, retries included. A batch that fails as a whole is retried; calls that fail on their
+// own keep their Error.
+func batchCall(ctx context.Context, client rpcBatchCaller, method string, calls []rpc.BatchElem) error {
+	for start := 0; start < len(calls); start += rpcBatchSize {
+		end := start + rpcBatchSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+		batch := calls[start:end]
+		err := retryRPC(ctx, method, func(ctx context.Context) error {
+			for range batch {
+				if err := limiter.Wait(ctx); err != nil {
+					return noRetry(err)
+				}
+			}
+			return client.BatchCallContext(ctx, batch)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// rpcErrorKind sorts failed RPC calls by what the caller can do about them.
+type rpcErrorKind int
+
+const (
+	rpcTransient     rpcErrorKind = iota // Network problems, timeouts and server errors; worth retrying
+	rpcRateLimited                       // The provider asked us to slow down; retried with a longer backoff
+	rpcReverted                          // The call executed and reverted; retrying gives the same answer
+	rpcMalformed                         // The response could not be decoded or made no sense
+	rpcRangeTooLarge                     // The request asked for more than the provider returns at once
+)
+
+func (k rpcErrorKind) String() string {
+	switch k {
+	case rpcTransient:
+		return "transient"
+	case rpcRateLimited:
+		return "rate limited"
+	case rpcReverted:
+		return "reverted"
+	case rpcMalformed:
+		return "malformed response"
+	case rpcRangeTooLarge:
+		return "range too large"
+	}
+	return "unknown"
+}
+
+const (
+	rpcMaxAttempts      = 8
+	rpcBaseBackoff      = 250 * time.Millisecond
+	rpcRateLimitBackoff = time.Second
+	rpcMaxBackoff       = 30 * time.Second
+)
+
+// Error messages providers use when an eth_getLogs range returns too many logs or spans too many blocks.
+var rangeTooLargeMessages = []string{
+	"query returned more than",
+	"more than 10000 results",
+	"response size exceeded",
+	"response size should not",
+	"block range",
+	"range is too large",
+	"range too large",
+	"too many results",
+	"too many logs",
+}
+
+// RPCError is returned by the RPC helpers once a call has failed for good.
+type RPCError struct {
+	Kind   rpcErrorKind
+	Method string
+	Err    error
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Method, e.Kind, e.Err)
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+// malformedResponse returns an RPCError for a response that could not be decoded.
+func malformedResponse(method, format string, args ...interface{}) error {
+	return &RPCError{Kind: rpcMalformed, Method: method, Err: fmt.Errorf(format, args...)}
+}
+
+// isRPCErrorKind reports whether err is an RPCError of the given kind.
+func isRPCErrorKind(err error, kind rpcErrorKind) bool {
+	var rpcErr *RPCError
+	return errors.As(err, &rpcErr) && rpcErr.Kind == kind
+}
+
+// noRetryError marks an error the caller wants returned immediately, whatever its kind.
+type noRetryError struct{ err error }
+
+func (e noRetryError) Error() string { return e.err.Error() }
+
+// noRetry stops retryRPC from retrying err.
+func noRetry(err error) error {
+	return noRetryError{err}
+}
+
+// classifyRPCError decides which kind of failure err is.
+func classifyRPCError(err error) rpcErrorKind {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Kind
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		return rpcRateLimited
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return rpcMalformed
+	}
+
+	var codeErr rpc.Error
+	if errors.As(err, &codeErr) {
+		switch codeErr.ErrorCode() {
+		case 3: // Execution reverted with data
+			return rpcReverted
+		case -32007, -32029: // Request limits used by common providers
+			return rpcRateLimited
+		}
+	}
+
+	// -32005 is used both for request limits and for queries returning too many results, so the
+	// message decides
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "rate limit"), strings.Contains(message, "too many requests"),
+		strings.Contains(message, "request limit"), strings.Contains(message, "credits"):
+		return rpcRateLimited
+	case containsAny(message, rangeTooLargeMessages):
+		return rpcRangeTooLarge
+	case errors.As(err, &codeErr) && codeErr.ErrorCode() == -32005:
+		return rpcRateLimited
+	case strings.Contains(message, "revert"), strings.Contains(message, "invalid opcode"),
+		strings.Contains(message, "invalid jump"):
+		return rpcReverted
+	case strings.Contains(message, "invalid character"), strings.Contains(message, "cannot unmarshal"),
+		strings.Contains(message, "type not supported"):
+		return rpcMalformed
+	}
+	return rpcTransient
+}
+
+// containsAny reports whether s contains any of the substrings.
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryRPC runs call until it succeeds or fails with an error that retrying cannot fix. Transient
+// and rate-limited failures are retried with jittered exponential backoff; every other failure, or
+// the last one, is returned as an *RPCError.
+func retryRPC(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := call(ctx)
+		if err == nil {
+			return nil
+		}
+		var stop noRetryError
+		if errors.As(err, &stop) {
+			return stop.err
+		}
+
+		kind := classifyRPCError(err)
+		if (kind != rpcTransient && kind != rpcRateLimited) || attempt == rpcMaxAttempts || ctx.Err() != nil {
+			return &RPCError{Kind: kind, Method: method, Err: err}
+		}
+
+		// Full jitter: sleep a random duration up to the exponential backoff
+		backoff := rpcBaseBackoff
+		if kind == rpcRateLimited {
+			backoff = rpcRateLimitBackoff
+		}
+		backoff <<= attempt - 1
+		if backoff > rpcMaxBackoff {
+			backoff = rpcMaxBackoff
+		}
+		sleep := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		log.Printf("%s failed (%s, attempt %d of %d): %v; retrying in %s", method, kind, attempt, rpcMaxAttempts, err, sleep.Round(time.Millisecond))
+
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return &RPCError{Kind: kind, Method: method, Err: err}
+		}
+	}
+}
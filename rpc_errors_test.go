@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestClassifyRPCError(t *testing.T) {
+	syntaxErr := json.Unmarshal([]byte("{"), new(interface{}))
+
+	examples := map[rpcErrorKind][]error{
+		rpcRateLimited: {
+			rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"},
+			errors.New("429 Too Many Requests"),
+			errors.New("project ID request rate limit exceeded"),
+			errors.New("monthly credits used up"),
+			codeError{-32005, "limit exceeded"},
+			codeError{-32007, "100/second request limit reached"},
+			codeError{-32029, "exceeded"},
+		},
+		rpcReverted: {
+			codeError{3, "execution reverted: paused"},
+			errors.New("execution reverted"),
+			errors.New("invalid opcode: INVALID"),
+		},
+		rpcMalformed: {
+			syntaxErr,
+			errors.New("json: cannot unmarshal string into Go value of type hexutil.Uint64"),
+			fmt.Errorf("token 0x1: %w", &RPCError{Kind: rpcMalformed, Method: "eth_call", Err: errors.New("empty")}),
+		},
+		rpcRangeTooLarge: {
+			codeError{-32005, "query returned more than 10000 results"},
+			codeError{-32602, "eth_getLogs is limited to a 10,000 block range"},
+			errors.New("too many logs in the requested range"),
+			errors.New("Log response size exceeded."),
+		},
+		rpcTransient: {
+			errors.New("read tcp: connection reset by peer"),
+			rpc.HTTPError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"},
+			context.DeadlineExceeded,
+		},
+	}
+	for want, errs := range examples {
+		for _, err := range errs {
+			if got := classifyRPCError(err); got != want {
+				t.Errorf("classifyRPCError(%v) = %s, want %s", err, got, want)
+			}
+		}
+	}
+}
+
+func TestRetryRPC(t *testing.T) {
+	t.Run("transient failures are retried", func(t *testing.T) {
+		calls := 0
+		err := retryRPC(context.Background(), "eth_call", func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return errors.New("connection reset by peer")
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("retryRPC = %v after %d calls, want success after 3", err, calls)
+		}
+	})
+
+	t.Run("failures retrying cannot fix stop at once", func(t *testing.T) {
+		for _, failure := range []error{
+			codeError{3, "execution reverted"},
+			errors.New("invalid character 'x' looking for beginning of value"),
+			codeError{-32005, "query returned more than 10000 results"},
+		} {
+			calls := 0
+			err := retryRPC(context.Background(), "eth_call", func(ctx context.Context) error {
+				calls++
+				return failure
+			})
+			var rpcErr *RPCError
+			if calls != 1 || !errors.As(err, &rpcErr) || rpcErr.Err != failure || rpcErr.Method != "eth_call" {
+				t.Errorf("retryRPC of %q = %v after %d calls, want it wrapped in an RPCError after 1", failure, err, calls)
+			}
+		}
+	})
+
+	t.Run("noRetry returns the error itself", func(t *testing.T) {
+		failure := errors.New("connection reset by peer")
+		calls := 0
+		err := retryRPC(context.Background(), "eth_call", func(ctx context.Context) error {
+			calls++
+			return noRetry(failure)
+		})
+		if calls != 1 || err != failure {
+			t.Errorf("retryRPC = %v after %d calls, want %v after 1", err, calls, failure)
+		}
+	})
+
+	t.Run("cancellation ends the backoff", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := retryRPC(ctx, "eth_call", func(ctx context.Context) error {
+			return rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}
+		})
+		if !isRPCErrorKind(err, rpcRateLimited) {
+			t.Errorf("retryRPC = %v, want a rate limited RPCError", err)
+		}
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	headCheckInterval = 10 * time.Second
+	maxHeadLag        = 5   // Endpoints more than this many blocks behind the best head are skipped
+	maxErrorRate      = 0.5 // Endpoints failing more often than this are skipped
+	healthDecay       = 0.2 // Weight of the newest sample in the latency and error rate averages
+)
+
+// rpcCaller is the part of rpc.Client used for raw JSON-RPC calls. Both *rpc.Client and *RPCPool implement it.
+type rpcCaller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+// rpcBatchCaller also sends JSON-RPC batches. Both *rpc.Client and *RPCPool implement it.
+type rpcBatchCaller interface {
+	rpcCaller
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
+
+// logFetcher is the part of ethclient.Client used to fetch logs. Both *ethclient.Client and *RPCPool implement it.
+type logFetcher interface {
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// rpcEndpoint is one RPC provider in a pool, together with its health.
+type rpcEndpoint struct {
+	url string
+	rpc *rpc.Client
+	eth *ethclient.Client
+
+	mu        sync.Mutex
+	latency   time.Duration // Moving average of successful call latency
+	errorRate float64       // Moving average of transient and rate-limited failures
+	head      uint64        // Last head height the endpoint reported
+}
+
+// observe updates the endpoint's health after a call that took elapsed and ended with err.
+func (e *rpcEndpoint) observe(elapsed time.Duration, err error) {
+	failed := 0.0
+	if err != nil {
+		kind := classifyRPCError(err)
+		if kind != rpcTransient && kind != rpcRateLimited {
+			failed = -1 // The endpoint answered; the call itself was bad or asked for too much
+		} else {
+			failed = 1
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if failed >= 0 {
+		e.errorRate = (1-healthDecay)*e.errorRate + healthDecay*failed
+	}
+	if err == nil {
+		if e.latency == 0 {
+			e.latency = elapsed
+		} else {
+			e.latency = time.Duration((1-healthDecay)*float64(e.latency) + healthDecay*float64(elapsed))
+		}
+	}
+}
+
+// score ranks endpoints; lower is better.
+func (e *rpcEndpoint) score() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return float64(e.latency+time.Millisecond) * (1 + 10*e.errorRate)
+}
+
+// RPCPool spreads calls over several RPC endpoints. It prefers the fastest healthy endpoint, skips
+// endpoints that fail often or lag behind the others, and fails over to the next one when a call fails.
+type RPCPool struct {
+	endpoints []*rpcEndpoint
+	cancel    context.CancelFunc
+}
+
+// rpcURLs splits a comma separated list of endpoint URLs.
+func rpcURLs(list string) []string {
+	var urls []string
+	for _, url := range strings.Split(list, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// dialRPCPool connects to every endpoint and starts tracking their head heights.
+func dialRPCPool(urls []string) (*RPCPool, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no RPC endpoints configured")
+	}
+
+	pool := &RPCPool{}
+	for _, url := range urls {
+		client, err := rpc.Dial(url)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to connect to %s: %v", url, err)
+		}
+		pool.endpoints = append(pool.endpoints, &rpcEndpoint{url: url, rpc: client, eth: ethclient.NewClient(client)})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.cancel = cancel
+	pool.checkHeads(ctx)
+	go pool.trackHeads(ctx)
+	return pool, nil
+}
+
+// Close stops the health checks and closes every endpoint.
+func (p *RPCPool) Close() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	for _, e := range p.endpoints {
+		e.rpc.Close()
+	}
+}
+
+// trackHeads refreshes the head height of every endpoint until ctx is cancelled.
+func (p *RPCPool) trackHeads(ctx context.Context) {
+	ticker := time.NewTicker(headCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkHeads(ctx)
+		}
+	}
+}
+
+// checkHeads asks every endpoint for its head height in parallel.
+func (p *RPCPool) checkHeads(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *rpcEndpoint) {
+			defer wg.Done()
+			callCtx, cancel := context.WithTimeout(ctx, headCheckInterval)
+			defer cancel()
+
+			var head hexutil.Uint64
+			start := time.Now()
+			err := e.rpc.CallContext(callCtx, &head, "eth_blockNumber")
+			e.observe(time.Since(start), err)
+			if err != nil {
+				log.Printf("Health check of %s failed: %v", e.url, err)
+				return
+			}
+			e.mu.Lock()
+			e.head = uint64(head)
+			e.mu.Unlock()
+		}(e)
+	}
+	wg.Wait()
+}
+
+// ranked returns the endpoints in the order calls should try them: healthy endpoints by score,
+// then the rest, so that a call still goes somewhere when every endpoint is struggling.
+func (p *RPCPool) ranked() []*rpcEndpoint {
+	var bestHead uint64
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		if e.head > bestHead {
+			bestHead = e.head
+		}
+		e.mu.Unlock()
+	}
+
+	var healthy, unhealthy []*rpcEndpoint
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		ok := e.head+maxHeadLag >= bestHead && e.errorRate <= maxErrorRate
+		e.mu.Unlock()
+		if ok {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].score() < healthy[j].score() })
+	sort.SliceStable(unhealthy, func(i, j int) bool { return unhealthy[i].score() < unhealthy[j].score() })
+	return append(healthy, unhealthy...)
+}
+
+// do runs call against the best endpoint, failing over to the next one on transient or
+// rate-limited errors. Other errors come from the call itself and are returned at once, so an
+// oversized eth_getLogs is split by the caller rather than replayed on every endpoint.
+func (p *RPCPool) do(ctx context.Context, call func(e *rpcEndpoint) error) error {
+	var err error
+	for _, e := range p.ranked() {
+		start := time.Now()
+		err = call(e)
+		e.observe(time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+		if kind := classifyRPCError(err); kind != rpcTransient && kind != rpcRateLimited {
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if len(p.endpoints) > 1 {
+			log.Printf("Endpoint %s failed: %v; failing over", e.url, err)
+		}
+	}
+	return err
+}
+
+// CallContext performs a JSON-RPC call like rpc.Client.CallContext.
+func (p *RPCPool) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return p.do(ctx, func(e *rpcEndpoint) error {
+		return e.rpc.CallContext(ctx, result, method, args...)
+	})
+}
+
+// BatchCallContext sends a JSON-RPC batch like rpc.Client.BatchCallContext. Only a failure of the
+// whole batch fails over; errors of single calls are left in their BatchElem.
+func (p *RPCPool) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return p.do(ctx, func(e *rpcEndpoint) error {
+		return e.rpc.BatchCallContext(ctx, b)
+	})
+}
+
+// Call performs a JSON-RPC call like rpc.Client.Call.
+func (p *RPCPool) Call(result interface{}, method string, args ...interface{}) error {
+	return p.CallContext(context.Background(), result, method, args...)
+}
+
+// FilterLogs fetches logs like ethclient.Client.FilterLogs.
+func (p *RPCPool) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	err := p.do(ctx, func(e *rpcEndpoint) error {
+		var err error
+		logs, err = e.eth.FilterLogs(ctx, q)
+		return err
+	})
+	return logs, err
+}
+
+// BlockByNumber fetches a block like ethclient.Client.BlockByNumber.
+func (p *RPCPool) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	var block *types.Block
+	err := p.do(ctx, func(e *rpcEndpoint) error {
+		var err error
+		block, err = e.eth.BlockByNumber(ctx, number)
+		return err
+	})
+	return block, err
+}
+
+// BlockNumber returns the head height like ethclient.Client.BlockNumber.
+func (p *RPCPool) BlockNumber(ctx context.Context) (uint64, error) {
+	var head uint64
+	err := p.do(ctx, func(e *rpcEndpoint) error {
+		var err error
+		head, err = e.eth.BlockNumber(ctx)
+		return err
+	})
+	return head, err
+}
+
+// ChainID returns the chain id like ethclient.Client.ChainID.
+func (p *RPCPool) ChainID(ctx context.Context) (*big.Int, error) {
+	var chainID *big.Int
+	err := p.do(ctx, func(e *rpcEndpoint) error {
+		var err error
+		chainID, err = e.eth.ChainID(ctx)
+		return err
+	})
+	return chainID, err
+}
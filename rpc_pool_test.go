@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeEndpoint is one provider of a pool. It reports head as its block number and answers
+// eth_chainId, or fails it with err while err is set.
+type fakeEndpoint struct {
+	mu    sync.Mutex
+	head  uint64
+	err   error
+	calls int // eth_chainId requests received
+}
+
+func (e *fakeEndpoint) BlockNumber() hexutil.Uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return hexutil.Uint64(e.head)
+}
+
+func (e *fakeEndpoint) ChainId() (*hexutil.Big, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls++
+	if e.err != nil {
+		return nil, e.err
+	}
+	return (*hexutil.Big)(big.NewInt(8453)), nil
+}
+
+// fail makes eth_chainId fail with err, or succeed again if err is nil.
+func (e *fakeEndpoint) fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.err = err
+}
+
+// newFakePool returns a pool over in-process endpoints that has checked their heads once, with
+// the given average latencies in place of the measured ones.
+func newFakePool(t *testing.T, endpoints []*fakeEndpoint, latencies []time.Duration) *RPCPool {
+	pool := &RPCPool{}
+	for i, endpoint := range endpoints {
+		server := rpc.NewServer()
+		if err := server.RegisterName("eth", endpoint); err != nil {
+			t.Fatal(err)
+		}
+		client := rpc.DialInProc(server)
+		pool.endpoints = append(pool.endpoints, &rpcEndpoint{url: fmt.Sprintf("endpoint-%d", i), rpc: client, eth: ethclient.NewClient(client)})
+	}
+	t.Cleanup(pool.Close)
+
+	pool.checkHeads(context.Background())
+	for i, e := range pool.endpoints {
+		e.latency = latencies[i]
+	}
+	return pool
+}
+
+// requests returns how many eth_chainId requests the endpoint received.
+func (e *fakeEndpoint) requests() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+// servedBy calls eth_chainId on pool and returns how many requests each endpoint received for it.
+func servedBy(pool *RPCPool, endpoints []*fakeEndpoint) ([]int, error) {
+	calls := make([]int, len(endpoints))
+	for i, endpoint := range endpoints {
+		calls[i] = -endpoint.requests()
+	}
+	_, err := pool.ChainID(context.Background())
+	for i, endpoint := range endpoints {
+		calls[i] += endpoint.requests()
+	}
+	return calls, err
+}
+
+func TestRPCPoolSkipsLaggingEndpoint(t *testing.T) {
+	synced, lagging := &fakeEndpoint{head: 100}, &fakeEndpoint{head: 100 - maxHeadLag - 1}
+	endpoints := []*fakeEndpoint{synced, lagging}
+	pool := newFakePool(t, endpoints, []time.Duration{50 * time.Millisecond, time.Millisecond})
+
+	calls, err := servedBy(pool, endpoints)
+	if err != nil || calls[0] != 1 || calls[1] != 0 {
+		t.Errorf("with the faster endpoint %d blocks behind, requests went %v (%v), want only to the synced one", maxHeadLag+1, calls, err)
+	}
+
+	// Once it is back within maxHeadLag blocks, the faster endpoint is preferred again
+	lagging.mu.Lock()
+	lagging.head = 100 - maxHeadLag
+	lagging.mu.Unlock()
+	pool.checkHeads(context.Background())
+	calls, err = servedBy(pool, endpoints)
+	if err != nil || calls[0] != 0 || calls[1] != 1 {
+		t.Errorf("with the faster endpoint %d blocks behind, requests went %v (%v), want only to the faster one", maxHeadLag, calls, err)
+	}
+}
+
+func TestRPCPoolDemotesFailingEndpoint(t *testing.T) {
+	failing, healthy := &fakeEndpoint{head: 100, err: errors.New("upstream connect error")}, &fakeEndpoint{head: 100}
+	endpoints := []*fakeEndpoint{failing, healthy}
+	pool := newFakePool(t, endpoints, []time.Duration{time.Millisecond, 50 * time.Millisecond})
+
+	// The faster endpoint keeps being tried first until its error rate passes maxErrorRate
+	tried := 0
+	for i := 0; i < 6; i++ {
+		calls, err := servedBy(pool, endpoints)
+		if err != nil || calls[1] != 1 {
+			t.Fatalf("call %d went %v (%v), want it answered by the healthy endpoint", i+1, calls, err)
+		}
+		tried += calls[0]
+	}
+	if tried != 4 {
+		t.Errorf("the failing endpoint was tried %d times, want 4 before its error rate passes %v", tried, maxErrorRate)
+	}
+	if ranked := pool.ranked(); ranked[0] != pool.endpoints[1] {
+		t.Errorf("pool ranks %s first, want the healthy endpoint", ranked[0].url)
+	}
+}
+
+func TestRPCPoolFailover(t *testing.T) {
+	first, second := &fakeEndpoint{head: 100}, &fakeEndpoint{head: 100}
+	endpoints := []*fakeEndpoint{first, second}
+	pool := newFakePool(t, endpoints, []time.Duration{time.Millisecond, 50 * time.Millisecond})
+
+	first.fail(errors.New("upstream connect error"))
+	if calls, err := servedBy(pool, endpoints); err != nil || calls[0] != 1 || calls[1] != 1 {
+		t.Errorf("after a transient failure requests went %v (%v), want one to each endpoint and success", calls, err)
+	}
+
+	// A revert is the call's own answer; another endpoint would give the same one
+	first.fail(codeError{3, "execution reverted"})
+	if calls, err := servedBy(pool, endpoints); classifyRPCError(err) != rpcReverted || calls[1] != 0 {
+		t.Errorf("after a revert requests went %v (%v), want the revert returned without failing over", calls, err)
+	}
+
+	// With every endpoint failing, each is tried once and the last error returned
+	first.fail(errors.New("upstream connect error"))
+	second.fail(errors.New("502 Bad Gateway"))
+	if calls, err := servedBy(pool, endpoints); err == nil || err.Error() != "502 Bad Gateway" || calls[0] != 1 || calls[1] != 1 {
+		t.Errorf("with every endpoint failing requests went %v (%v), want one to each and the last error", calls, err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	spenderBatchSize    = 100             // Spenders checked per round
+	spenderPollInterval = 5 * time.Second // Wait between rounds when no spender is pending
+	maxSpenderAttempts  = 5               // Rounds a spender is retried after the node failed
+)
+
+// spendersTable records each spender the first time an approval names it. Whether it is a contract
+// and when it was deployed are filled in afterwards by enrichSpenders.
+var spendersTable = &batchTable{
+	name:      "spenders",
+	columns:   []string{"chain_id", "address", "first_seen_block"},
+	conflict:  `ON CONFLICT (chain_id, address) DO UPDATE SET first_seen_block = LEAST(spenders.first_seen_block, EXCLUDED.first_seen_block)`,
+	key:       []int{0, 1},
+	keepFirst: true,
+}
+
+// queueSpender records a spender seen in blockNumber. The row is written with the approval naming it.
+func queueSpender(spender common.Address, blockNumber uint64) {
+	rowWriter.add(spendersTable, chainID, spender.Hex(), blockNumber)
+}
+
+// pendingSpenders returns spenders that have not been checked and have not used up their attempts,
+// oldest first.
+func pendingSpenders() ([]common.Address, error) {
+	query := `
+        SELECT address FROM spenders
+        WHERE chain_id = $1 AND checked_at IS NULL AND attempts < $2
+        ORDER BY attempts, first_seen_block
+        LIMIT $3
+    `
+	rows, err := db.Query(query, chainID, maxSpenderAttempts, spenderBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var spenders []common.Address
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		spenders = append(spenders, common.HexToAddress(address))
+	}
+	return spenders, rows.Err()
+}
+
+// spenderDeployment returns the block and time a contract spender was deployed.
+func spenderDeployment(client rpcCaller, spender common.Address, head uint64) (sql.NullInt64, sql.NullTime, error) {
+	block, err := findDeploymentBlock(client, spender, head)
+	if err != nil {
+		return sql.NullInt64{}, sql.NullTime{}, err
+	}
+	deployedAt, err := blockTimestamp(block)
+	if err != nil {
+		return sql.NullInt64{}, sql.NullTime{}, err
+	}
+	return sql.NullInt64{Int64: int64(block), Valid: true}, sql.NullTime{Time: deployedAt, Valid: true}, nil
+}
+
+// failSpender counts a failed attempt to check the spender, so it is tried again in a later round.
+func failSpender(spender common.Address, err error) {
+	log.Printf("Failed to check spender %s: %v", spender.Hex(), err)
+	_, err = db.Exec(`UPDATE spenders SET attempts = attempts + 1, last_error = $3 WHERE chain_id = $1 AND address = $2`,
+		chainID, spender.Hex(), err.Error())
+	if err != nil {
+		log.Printf("Failed to update spender %s: %v", spender.Hex(), err)
+	}
+}
+
+// enrichSpender checks and stores one spender. Whether it has code is stored as soon as it is known,
+// so a contract keeps that flag even when the node cannot date its deployment. When the node fails
+// the attempt is counted and the spender is tried again in a later round.
+func enrichSpender(ctx context.Context, client rpcCaller, spender common.Address, head uint64) {
+	isContract, err := hasCode(client, spender, hexutil.EncodeUint64(head))
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		failSpender(spender, err)
+		return
+	}
+	_, err = db.Exec(`UPDATE spenders SET is_contract = $3 WHERE chain_id = $1 AND address = $2`, chainID, spender.Hex(), isContract)
+	if err != nil {
+		log.Printf("Failed to store spender %s: %v", spender.Hex(), err)
+		return
+	}
+
+	var deploymentBlock sql.NullInt64
+	var deployedAt sql.NullTime
+	if isContract {
+		deploymentBlock, deployedAt, err = spenderDeployment(client, spender, head)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failSpender(spender, err)
+			return
+		}
+	}
+
+	query := `
+        UPDATE spenders
+        SET deployment_block = $3, deployed_at = $4, checked_at = now(), attempts = attempts + 1, last_error = NULL
+        WHERE chain_id = $1 AND address = $2
+    `
+	_, err = db.Exec(query, chainID, spender.Hex(), deploymentBlock, deployedAt)
+	if err != nil {
+		log.Printf("Failed to store spender %s: %v", spender.Hex(), err)
+	}
+}
+
+// enrichSpenders checks newly seen spenders until ctx is cancelled. Like enrichTokens it runs
+// beside the scan, and picks up spenders left over from earlier runs. A deployment search takes
+// about 25 calls that all wait on the shared limiter, so spenders are checked one at a time.
+func enrichSpenders(ctx context.Context, client rpcCaller) {
+	for {
+		spenders, err := pendingSpenders()
+		if err != nil {
+			log.Printf("Failed to load pending spenders: %v", err)
+		}
+
+		if len(spenders) > 0 {
+			var head *blockRef
+			if head, err = fetchBlockRef(ctx, client, "latest"); err != nil {
+				log.Printf("Failed to fetch the chain head: %v", err)
+			} else {
+				for _, spender := range spenders {
+					enrichSpender(ctx, client, spender, uint64(head.Number))
+				}
+				log.Printf("Checked %d spenders", len(spenders))
+			}
+		}
+
+		// A full round means more spenders are probably waiting
+		if len(spenders) == spenderBatchSize && err == nil && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(spenderPollInterval):
+		}
+	}
+}
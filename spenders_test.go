@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEnrichSpenderWithoutArchive(t *testing.T) {
+	spender := common.HexToAddress("0x3fC91A3afd70395Cd496C647d5a6CC9D4B2b7FAD")
+	fake := useFakeDB(t)
+
+	// The spender has code at the head, but its deployment is older than the node's state
+	node := &fakeCodeNode{deployed: 1371714, prunedBefore: 19990000}
+	enrichSpender(context.Background(), node, spender, 20000000)
+
+	statements := fake.executed()
+	if len(statements) != 2 {
+		t.Fatalf("enrichSpender ran %d statements, want 2", len(statements))
+	}
+	if !strings.Contains(statements[0].query, "SET is_contract") || statements[0].args[2] != true {
+		t.Errorf("first statement %q %v, want is_contract stored as true", statements[0].query, statements[0].args)
+	}
+	if !strings.Contains(statements[1].query, "attempts = attempts + 1, last_error") || strings.Contains(statements[1].query, "checked_at") {
+		t.Errorf("second statement %q, want the failed attempt counted without checking the spender", statements[1].query)
+	}
+}
+
+func TestEnrichSpenderAccount(t *testing.T) {
+	spender := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	fake := useFakeDB(t)
+
+	node := &fakeCodeNode{deployed: 1 << 40}
+	enrichSpender(context.Background(), node, spender, 20000000)
+
+	statements := fake.executed()
+	if len(statements) != 2 || statements[0].args[2] != false || !strings.Contains(statements[1].query, "checked_at = now()") {
+		t.Errorf("enrichSpender of an account ran %v, want is_contract false and the spender checked", statements)
+	}
+	if node.calls != 1 {
+		t.Errorf("enrichSpender of an account made %d calls, want 1", node.calls)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// SwapRecord is one trade in a pool, normalized to amounts in and out of the pool for each token.
+type SwapRecord struct {
+	Pool       common.Address
+	Sender     common.Address
+	Recipient  common.Address
+	Amount0In  *big.Int
+	Amount1In  *big.Int
+	Amount0Out *big.Int
+	Amount1Out *big.Int
+
+	// Pool state after the swap; concentrated-liquidity pools only
+	SqrtPriceX96 *big.Int
+	Liquidity    *big.Int
+	Tick         sql.NullInt64
+
+	Log types.Log
+}
+
+// The swap events of every supported pool type.
+func init() {
+	swaps := registerEventGroup("swaps", "swaps")
+
+	// Uniswap V2 and its forks, and Solidly forks (Velodrome V1 style)
+	swaps.register("event Swap(address indexed sender, uint256 amount0In, uint256 amount1In, uint256 amount0Out, uint256 amount1Out, address indexed to)",
+		reserveSwap("sender", "to"))
+
+	// Aerodrome
+	swaps.register("event Swap(address indexed sender, address indexed to, uint256 amount0In, uint256 amount1In, uint256 amount0Out, uint256 amount1Out)",
+		reserveSwap("sender", "to"))
+
+	// Uniswap V3 and its forks
+	swaps.register("event Swap(address indexed sender, address indexed recipient, int256 amount0, int256 amount1, uint160 sqrtPriceX96, uint128 liquidity, int24 tick)",
+		concentratedSwap)
+}
+
+// reserveSwap decodes a swap that reports the amounts in and out of the pool separately.
+func reserveSwap(sender, recipient string) func(vLog types.Log, values map[string]interface{}) error {
+	return func(vLog types.Log, values map[string]interface{}) error {
+		record := SwapRecord{Pool: vLog.Address, Log: vLog}
+		var err error
+		if record.Sender, err = addressValue(values, sender); err != nil {
+			return err
+		}
+		if record.Recipient, err = addressValue(values, recipient); err != nil {
+			return err
+		}
+		amounts := []**big.Int{&record.Amount0In, &record.Amount1In, &record.Amount0Out, &record.Amount1Out}
+		for i, name := range []string{"amount0In", "amount1In", "amount0Out", "amount1Out"} {
+			if *amounts[i], err = bigValue(values, name); err != nil {
+				return err
+			}
+		}
+		insertSwap(record)
+		return nil
+	}
+}
+
+// concentratedSwap decodes a concentrated-liquidity swap. Its signed amounts are positive when the
+// token goes into the pool and negative when it leaves.
+func concentratedSwap(vLog types.Log, values map[string]interface{}) error {
+	record := SwapRecord{Pool: vLog.Address, Log: vLog}
+	var err error
+	if record.Sender, err = addressValue(values, "sender"); err != nil {
+		return err
+	}
+	if record.Recipient, err = addressValue(values, "recipient"); err != nil {
+		return err
+	}
+	amount0, err := bigValue(values, "amount0")
+	if err != nil {
+		return err
+	}
+	amount1, err := bigValue(values, "amount1")
+	if err != nil {
+		return err
+	}
+	record.Amount0In, record.Amount0Out = splitSigned(amount0)
+	record.Amount1In, record.Amount1Out = splitSigned(amount1)
+
+	if record.SqrtPriceX96, err = bigValue(values, "sqrtPriceX96"); err != nil {
+		return err
+	}
+	if record.Liquidity, err = bigValue(values, "liquidity"); err != nil {
+		return err
+	}
+	tick, err := intValue(values, "tick", -1<<23, 1<<23-1)
+	if err != nil {
+		return err
+	}
+	record.Tick = sql.NullInt64{Int64: tick, Valid: true}
+
+	insertSwap(record)
+	return nil
+}
+
+// splitSigned turns a signed pool delta into the amounts going in and coming out.
+func splitSigned(amount *big.Int) (in, out *big.Int) {
+	if amount.Sign() >= 0 {
+		return amount, new(big.Int)
+	}
+	return new(big.Int), new(big.Int).Neg(amount)
+}
+
+// swapsTable writes swaps keyed by their log, so rescanning a range rewrites the same rows.
+var swapsTable = &batchTable{
+	name: "swaps",
+	columns: []string{"chain_id", "pool_address", "block_number", "block_hash", "block_timestamp", "tx_hash", "log_index",
+		"sender", "recipient", "amount0_in", "amount1_in", "amount0_out", "amount1_out",
+		"sqrt_price_x96", "liquidity", "tick", "event_signature"},
+	conflict: `
+        ON CONFLICT (chain_id, tx_hash, log_index) DO UPDATE SET
+            pool_address    = EXCLUDED.pool_address,
+            block_number    = EXCLUDED.block_number,
+            block_hash      = EXCLUDED.block_hash,
+            block_timestamp = COALESCE(EXCLUDED.block_timestamp, swaps.block_timestamp),
+            sender          = EXCLUDED.sender,
+            recipient       = EXCLUDED.recipient,
+            amount0_in      = EXCLUDED.amount0_in,
+            amount1_in      = EXCLUDED.amount1_in,
+            amount0_out     = EXCLUDED.amount0_out,
+            amount1_out     = EXCLUDED.amount1_out,
+            sqrt_price_x96  = EXCLUDED.sqrt_price_x96,
+            liquidity       = EXCLUDED.liquidity,
+            tick            = EXCLUDED.tick,
+            event_signature = EXCLUDED.event_signature
+    `,
+	key: []int{0, 5, 6},
+}
+
+// insertSwap queues a swap. The row is written when the scanner flushes rowWriter.
+func insertSwap(record SwapRecord) {
+	vLog := record.Log
+	rowWriter.add(swapsTable, chainID, record.Pool.Hex(), vLog.BlockNumber, vLog.BlockHash.Hex(), logTimestamp(vLog), vLog.TxHash.Hex(), vLog.Index,
+		record.Sender.Hex(), record.Recipient.Hex(),
+		record.Amount0In.String(), record.Amount1In.String(), record.Amount0Out.String(), record.Amount1Out.String(),
+		numericOrNull(record.SqrtPriceX96), numericOrNull(record.Liquidity), record.Tick, eventSignature(vLog))
+}
+
+// numericOrNull formats an optional integer for a NUMERIC column.
+func numericOrNull(value *big.Int) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: value.String(), Valid: true}
+}
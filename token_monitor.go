@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/time/rate"
+)
+
+var limiter = rate.NewLimiter(rate.Limit(24), 1) // 24 requests per second
+
+const (
+	infuraURL = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
+
+	tokenMonitorScanner = "token_monitor" // Reorg guard name; each event group checkpoints as token_monitor:<group>
+)
+
+// tokenHistoryScanner returns the checkpoint name of a group's scan of a token's history before the
+// token was first seen in a pool: token_monitor:<group>:<token>.
+func tokenHistoryScanner(group *eventGroup, token common.Address) string {
+	return groupScanner(tokenMonitorScanner, group) + ":" + strings.ToLower(token.Hex())
+}
+
+// scanTokenRange indexes the events of the groups emitted by every known token in [fromBlock, toBlock]
+// and checkpoints the groups in the same transaction. Tokens seen for the first time have their
+// earlier history indexed first.
+func scanTokenRange(client *RPCPool, pager *logPager, tokens *contractSet, groups []*eventGroup, histories map[string]uint64, fromBlock, toBlock uint64) {
+	// Tokens loaded at startup were scanned from their first-seen block. Ones that turn up later,
+	// possibly with a first-seen block the scan has already passed, are only scanned from this range.
+	var scannedFrom uint64
+	if tokens.loaded {
+		scannedFrom = fromBlock
+	}
+	addresses, added := tokens.upTo(toBlock)
+	for _, token := range added {
+		scanTokenHistory(client, token, groups, histories, scannedFrom)
+	}
+
+	logs := fetchContractLogs(client, pager, addresses, eventTopics(groups), fromBlock, toBlock)
+	log.Printf("Processing blocks %d to %d: %d tokens, found %d logs", fromBlock, toBlock, len(addresses), len(logs))
+
+	commitEventLogs(logs, groups, groupScanners(tokenMonitorScanner, groups), fromBlock, toBlock)
+}
+
+// scanTokenHistory indexes the events of a token from its deployment up to the block before it
+// was first seen in a pool, or before scannedFrom if that is later; the main scan covers it from
+// then on. Tokens are minted long before they are paired, so without this history the running
+// balances would not add up. Each group checkpoints its history per token, so finished histories
+// are skipped on later runs.
+func scanTokenHistory(client *RPCPool, token common.Address, groups []*eventGroup, histories map[string]uint64, scannedFrom uint64) {
+	var firstSeen uint64
+	var deployment sql.NullInt64
+	query := `SELECT first_seen_block, deployment_block FROM tokens WHERE chain_id = $1 AND address = $2`
+	if err := db.QueryRow(query, chainID, token.Hex()).Scan(&firstSeen, &deployment); err != nil {
+		log.Fatalf("Failed to load token %s: %v", token.Hex(), err)
+	}
+	coveredFrom := firstSeen
+	if scannedFrom > coveredFrom {
+		coveredFrom = scannedFrom
+	}
+	if coveredFrom == 0 {
+		return
+	}
+	endBlock := coveredFrom - 1
+
+	scanners := make([]string, len(groups))
+	fromBlock := endBlock + 1
+	for i, group := range groups {
+		scanners[i] = tokenHistoryScanner(group, token)
+		resume := uint64(0)
+		if lastBlock, ok := histories[scanners[i]]; ok {
+			resume = lastBlock + 1
+		} else {
+			if !deployment.Valid {
+				deployment = detectTokenDeployment(client, token, firstSeen)
+			}
+			resume = uint64(deployment.Int64)
+		}
+		if resume < fromBlock {
+			fromBlock = resume
+		}
+	}
+	if fromBlock > endBlock {
+		return
+	}
+
+	log.Printf("Indexing the history of token %s, blocks %d to %d", token.Hex(), fromBlock, endBlock)
+	pager := newLogPager()
+	topics := eventTopics(groups)
+	for fromBlock <= endBlock {
+		toBlock := pager.pageEnd(fromBlock, endBlock)
+		logs := fetchContractLogs(client, pager, []common.Address{token}, topics, fromBlock, toBlock)
+		commitEventLogs(logs, groups, scanners, fromBlock, toBlock)
+		fromBlock = toBlock + 1
+	}
+	for _, scanner := range scanners {
+		histories[scanner] = endBlock
+	}
+}
+
+// detectTokenDeployment finds and stores the first block with the token's code. If the search
+// fails, the token's history is scanned from block 0 and the search is retried on the next run.
+func detectTokenDeployment(client *RPCPool, token common.Address, firstSeen uint64) sql.NullInt64 {
+	block, err := findDeploymentBlock(client, token, firstSeen)
+	if err != nil {
+		log.Printf("Failed to find the deployment block of token %s, scanning from block 0: %v", token.Hex(), err)
+		return sql.NullInt64{Valid: true}
+	}
+	_, err = db.Exec(`UPDATE tokens SET deployment_block = $3 WHERE chain_id = $1 AND address = $2`, chainID, token.Hex(), block)
+	if err != nil {
+		log.Fatalf("Failed to store the deployment block of token %s: %v", token.Hex(), err)
+	}
+	return sql.NullInt64{Int64: int64(block), Valid: true}
+}
+
+func main() {
+	rewind := flag.Int64("rewind", -1, "rewind the checkpoints of the selected event groups so the scan restarts at this block")
+	follow := flag.Bool("follow", false, "keep indexing new blocks after the historical range is done")
+	confirmations := flag.Uint64("confirmations", 0, "only index blocks this many blocks behind the head")
+	headTag := flag.String("head", "latest", "block tag to treat as the head: latest, safe or finalized")
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	events := flag.String("events", "", "comma separated event groups to index; all groups when empty")
+	poolsScanner := flag.String("pools", "topic_monitor", "scanner that indexes the pools; events are indexed up to its checkpoint")
+	flag.Parse()
+
+	initDB() // Initialize the database
+
+	// "migrate up|down|status" only manages the schema; otherwise bring it up to date and scan
+	if flag.Arg(0) == "migrate" {
+		runMigrateCommand(flag.Args()[1:])
+		return
+	}
+	migrateUp()
+
+	groups := selectEventGroups(*events)
+	if *rewind >= 0 {
+		for _, group := range groups {
+			if err := rewindCheckpoint(groupScanner(tokenMonitorScanner, group), uint64(*rewind)); err != nil {
+				log.Fatalf("Failed to rewind: %v", err)
+			}
+		}
+	}
+
+	log.Println("Starting script...")
+
+	client, err := dialRPCPool(rpcURLs(*rpcList))
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+	defer client.Close()
+
+	initPairs(client)
+	go enrichSpenders(context.Background(), client)
+
+	// The main scan starts where the first token was seen; earlier blocks belong to token histories
+	var startBlock uint64
+	if err := db.QueryRow(`SELECT COALESCE(MIN(first_seen_block), 0) FROM tokens WHERE chain_id = $1`, chainID).Scan(&startBlock); err != nil {
+		log.Fatalf("Failed to find the first token: %v", err)
+	}
+
+	histories := loadCheckpoints(tokenMonitorScanner + ":")
+	monitor := &contractMonitor{
+		scanner:       tokenMonitorScanner,
+		groups:        groups,
+		contracts:     &contractSet{table: "tokens", addressColumn: "address", blockColumn: "first_seen_block"},
+		poolsScanner:  *poolsScanner,
+		startBlock:    startBlock,
+		headTag:       *headTag,
+		confirmations: *confirmations,
+		scanRange: func(client *RPCPool, pager *logPager, tokens *contractSet, groups []*eventGroup, fromBlock, toBlock uint64) {
+			scanTokenRange(client, pager, tokens, groups, histories, fromBlock, toBlock)
+		},
+	}
+	monitor.run(client, *follow)
+
+	log.Println("Script completed.")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	tokenBatchSize    = 100             // Tokens fetched per round
+	tokenWorkers      = 4               // Tokens fetched concurrently
+	tokenPollInterval = 5 * time.Second // Wait between rounds when no token is pending
+	maxTokenAttempts  = 5               // Rounds a token is retried after the node failed
+)
+
+// tokensTable records each token the first time a pool with it is seen. The metadata is filled in
+// afterwards by enrichTokens.
+var tokensTable = &batchTable{
+	name:      "tokens",
+	columns:   []string{"chain_id", "address", "first_seen_block"},
+	conflict:  `ON CONFLICT (chain_id, address) DO UPDATE SET first_seen_block = LEAST(tokens.first_seen_block, EXCLUDED.first_seen_block)`,
+	key:       []int{0, 1},
+	keepFirst: true,
+}
+
+// queueToken records a token seen in blockNumber. The row is written with the pool that uses it.
+func queueToken(token common.Address, blockNumber uint64) {
+	rowWriter.add(tokensTable, chainID, token.Hex(), blockNumber)
+}
+
+// tokenMetadata holds what a token reports about itself. Fields the token cannot report are NULL.
+type tokenMetadata struct {
+	name        sql.NullString
+	symbol      sql.NullString
+	decimals    sql.NullInt64
+	totalSupply sql.NullString
+}
+
+// fetchTokenMetadata calls the token's getters. A getter that reverts or returns something unusable
+// leaves its field NULL; only failures of the node itself are returned, so the token can be retried.
+func fetchTokenMetadata(ctx context.Context, client rpcCaller, token common.Address) (tokenMetadata, error) {
+	var metadata tokenMetadata
+	missing := func(getter string, err error) bool {
+		if isRPCErrorKind(err, rpcReverted) || isRPCErrorKind(err, rpcMalformed) {
+			log.Printf("No %s for token %s: %v", getter, token.Hex(), err)
+			return true
+		}
+		return false
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		return metadata, err
+	}
+	if name, err := getName(client, token); err == nil {
+		metadata.name = sql.NullString{String: name, Valid: true}
+	} else if !missing("name", err) {
+		return metadata, err
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		return metadata, err
+	}
+	if symbol, err := getSymbol(client, token); err == nil {
+		metadata.symbol = sql.NullString{String: symbol, Valid: true}
+	} else if !missing("symbol", err) {
+		return metadata, err
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		return metadata, err
+	}
+	if decimals, err := getDecimals(client, token); err == nil {
+		metadata.decimals = sql.NullInt64{Int64: int64(decimals), Valid: true}
+	} else if !missing("decimals", err) {
+		return metadata, err
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		return metadata, err
+	}
+	if supply, err := getTotalSupply(client, token); err == nil {
+		metadata.totalSupply = sql.NullString{String: supply.String(), Valid: true}
+	} else if !missing("totalSupply", err) {
+		return metadata, err
+	}
+	return metadata, nil
+}
+
+// pendingTokens returns tokens without metadata that have not used up their attempts, oldest first.
+func pendingTokens() ([]common.Address, error) {
+	query := `
+        SELECT address FROM tokens
+        WHERE chain_id = $1 AND fetched_at IS NULL AND attempts < $2
+        ORDER BY attempts, first_seen_block
+        LIMIT $3
+    `
+	rows, err := db.Query(query, chainID, maxTokenAttempts, tokenBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []common.Address
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, common.HexToAddress(address))
+	}
+	return tokens, rows.Err()
+}
+
+// enrichToken fetches and stores the metadata of one token. When the node fails the attempt is
+// counted and the token is tried again in a later round.
+func enrichToken(ctx context.Context, client rpcCaller, token common.Address) {
+	metadata, err := fetchTokenMetadata(ctx, client, token)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch metadata for token %s: %v", token.Hex(), err)
+		_, err = db.Exec(`UPDATE tokens SET attempts = attempts + 1, last_error = $3 WHERE chain_id = $1 AND address = $2`,
+			chainID, token.Hex(), err.Error())
+		if err != nil {
+			log.Printf("Failed to update token %s: %v", token.Hex(), err)
+		}
+		return
+	}
+
+	query := `
+        UPDATE tokens
+        SET name = $3, symbol = $4, decimals = $5, total_supply = $6, fetched_at = now(), attempts = attempts + 1, last_error = NULL
+        WHERE chain_id = $1 AND address = $2
+    `
+	_, err = db.Exec(query, chainID, token.Hex(), metadata.name, metadata.symbol, metadata.decimals, metadata.totalSupply)
+	if err != nil {
+		log.Printf("Failed to store metadata for token %s: %v", token.Hex(), err)
+	}
+}
+
+// enrichTokens fetches the metadata of newly seen tokens until ctx is cancelled. It runs beside the
+// scan, so slow or broken tokens never hold up indexing, and picks up tokens left over from earlier runs.
+func enrichTokens(ctx context.Context, client rpcCaller) {
+	for {
+		tokens, err := pendingTokens()
+		if err != nil {
+			log.Printf("Failed to load pending tokens: %v", err)
+		}
+
+		if len(tokens) > 0 {
+			jobs := make(chan common.Address)
+			var wg sync.WaitGroup
+			for i := 0; i < tokenWorkers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for token := range jobs {
+						enrichToken(ctx, client, token)
+					}
+				}()
+			}
+			for _, token := range tokens {
+				jobs <- token
+			}
+			close(jobs)
+			wg.Wait()
+			log.Printf("Fetched metadata for %d tokens", len(tokens))
+		}
+
+		// A full round means more tokens are probably waiting
+		if len(tokens) == tokenBatchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tokenPollInterval):
+		}
+	}
+}
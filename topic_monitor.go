@@ -2,197 +2,172 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"flag"
 	"log"
-	"math/big"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/rpc"
-	_ "github.com/lib/pq"
+	"github.com/ethereum/go-ethereum/core/types"
 	"golang.org/x/time/rate"
 )
 
 var limiter = rate.NewLimiter(rate.Limit(24), 1) // 24 requests per second
 
 const (
-	infuraURL           = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
-	pairCreatedTopic    = "0x783cca1c0412dd0d695e784568c96da2e9c22ff989357a2e8b1d9b2b4e6b7118" // Replace with the actual topic for PairCreated
-	poolCreatedTopic    = "0x0d3648bd0f6ba80134a33ba9275ac585d9d315f0ad8355cddefde31afa28d0e9" // Replace with the actual topic for PoolCreated
-	NewPoolCreatedTopic = "0xf04da67755adf58739649e2fb9949a6328518141b7ac9e44aa10320688b04900"
-	pairCreatedTopic_2  = "0xc4805696c66d7cf352fc1d6bb633ad5ee82f6cb577c453024b6e0eb8306c6fc9"
-	pairCreatedTopic_3  = "0x2128d88d14c80cb081c1252a5acff7a264671bf199ce226b53788fb26065005e"
-)
-
-var db *sql.DB
+	infuraURL = "https://flashy-intensive-glade.base-mainnet.discover.quiknode.pro/api-key/"
 
-func initDB() {
-	// Set up the database connection.
+	topicScanner = "topic_monitor" // Checkpoint name for this scanner
+)
 
-	connStr := "user=emmett dbname=cryptoarch sslmode=disable password=password"
-	var err error
-	db, err = sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+// topicQuery returns the log filter for every pool-creation event in the decoder registry.
+func topicQuery() ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		Topics: [][]common.Hash{poolCreationTopics()},
 	}
 }
 
-func insertPair(pairAddress, token0Address, token1Address, deployerAddress, factoryAddress string) {
-	query := `
-        INSERT INTO pairs (pair_address, token0_address, token1_address, deployer_address, factory_address)
-        VALUES ($1, $2, $3, $4, $5)
-    `
-	_, err := db.Exec(query, pairAddress, token0Address, token1Address, deployerAddress, factoryAddress)
+// scanTopicRange indexes every pool-creation log in [fromBlock, toBlock] and checkpoints the range.
+func scanTopicRange(client *RPCPool, pager *logPager, fromBlock, toBlock uint64) {
+	logs, err := pager.filterLogs(context.Background(), client, topicQuery(), fromBlock, toBlock)
 	if err != nil {
-		log.Printf("Failed to insert pair: %v", err)
+		log.Fatalf("Failed to filter logs: %v", err)
 	}
+	commitTopicRange(fromBlock, toBlock, logs)
 }
 
-func main() {
-	initDB() // Initialize the database
+// commitTopicRange stores the pairs from the logs of [fromBlock, toBlock] and checkpoints the range
+// in one transaction.
+// Ranges must be committed in block order.
+func commitTopicRange(fromBlock, toBlock uint64, logs []types.Log) {
+	log.Printf("Processing blocks %d to %d: found %d logs", fromBlock, toBlock, len(logs))
 
-	log.Println("Starting script...")
+	prefetchBlockTimes(logs)
+	prefetchSenders(logs)
+	for _, vLog := range logs {
+		handleTopicLog(vLog)
+	}
+
+	// The pairs and the checkpoint are written together, so a restart continues right after the
+	// last range that was stored
+	rowWriter.flush(toBlock, topicScanner)
+}
 
-	rpcClient, err := rpc.Dial(infuraURL)
+// handleTopicLog decodes a single pool-creation log and stores the pool.
+func handleTopicLog(vLog types.Log) {
+	record, err := decodePoolLog(vLog)
 	if err != nil {
-		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+		quarantineLog(vLog, err)
+		return
 	}
 
-	client := ethclient.NewClient(rpcClient)
+	log.Printf("New Pool Created: %s (%s), Tokens: %s, %s, Factory Address: %s, Event: %s", record.Pool.Hex(), record.Type, record.Token0.Hex(), record.Token1.Hex(), record.Factory.Hex(), record.Event)
+	insertPool(record)
+	noteFactoryCandidate(record)
+}
+
+func main() {
+	rewind := flag.Int64("rewind", -1, "rewind the checkpoint so the scan restarts at this block")
+	follow := flag.Bool("follow", false, "keep indexing new blocks after the historical range is done")
+	wsURL := flag.String("ws", "", "websocket endpoint used to subscribe to new logs in follow mode")
+	confirmations := flag.Uint64("confirmations", 0, "only index blocks this many blocks behind the head")
+	headTag := flag.String("head", "latest", "block tag to treat as the head: latest, safe or finalized")
+	workers := flag.Int("workers", 4, "number of block ranges fetched concurrently during the backfill")
+	rpcList := flag.String("rpc", infuraURL, "comma separated RPC endpoints to spread calls over")
+	discover := flag.Bool("discover", false, "queue factories missing from the factory registry for review")
+	flag.Parse()
 
-	startBlock := big.NewInt(0)     // Replace with the actual starting block number
-	endBlock := big.NewInt(4973333) // Replace with the actual ending block number or use nil for the latest block
+	initDB() // Initialize the database
 
-	pageSize := big.NewInt(10000) // Set page size to 10,000 blocks
+	// "migrate up|down|status" only manages the schema; otherwise bring it up to date and scan
+	if flag.Arg(0) == "migrate" {
+		runMigrateCommand(flag.Args()[1:])
+		return
+	}
+	migrateUp()
 
-	for currentPage := new(big.Int).Set(startBlock); currentPage.Cmp(endBlock) < 0; currentPage.Add(currentPage, pageSize) {
-		// Calculate fromBlock and toBlock for the current page
-		fromBlock := new(big.Int).Set(currentPage)
-		toBlock := new(big.Int).Add(new(big.Int).Set(currentPage), pageSize)
-		if toBlock.Cmp(endBlock) > 0 {
-			toBlock = endBlock
+	if *rewind >= 0 {
+		if err := rewindCheckpoint(topicScanner, uint64(*rewind)); err != nil {
+			log.Fatalf("Failed to rewind: %v", err)
 		}
+	}
 
-		log.Printf("Processing blocks %s to %s...\n", fromBlock.String(), toBlock.String())
-
-		query := ethereum.FilterQuery{
-			FromBlock: fromBlock,
-			ToBlock:   toBlock,
-			Topics: [][]common.Hash{
-				{
-					common.HexToHash(pairCreatedTopic),
-					common.HexToHash(poolCreatedTopic),
-					common.HexToHash(NewPoolCreatedTopic),
-					common.HexToHash(pairCreatedTopic_2),
-					common.HexToHash(pairCreatedTopic_3),
-				},
-			},
-		}
+	log.Println("Starting script...")
 
-		log.Printf("Filtering logs for block range %s to %s", fromBlock.String(), toBlock.String())
+	client, err := dialRPCPool(rpcURLs(*rpcList))
+	if err != nil {
+		log.Fatalf("Failed to connect to the Ethereum client: %v", err)
+	}
+	defer client.Close()
 
-		// Wait for permission from the rate limiter
-		if err := limiter.Wait(context.TODO()); err != nil {
-			log.Fatalf("Rate limiter error: %v", err)
-		}
+	initPairs(client)
+	go enrichTokens(context.Background(), client)
 
-		logs, err := client.FilterLogs(context.Background(), query)
+	// Every pool-creation log is scanned regardless of its emitter, so unknown factories show up here.
+	// Disabled factories count as known.
+	if *discover {
+		seedFactories()
+		factories, err := loadFactories(false)
 		if err != nil {
-			log.Fatalf("Failed to filter logs: %v", err)
+			log.Fatalf("Failed to load factories: %v", err)
 		}
+		enableDiscovery(factories)
+		go watchKnownFactories(context.Background(), factories)
+		go verifyCandidates(context.Background(), client)
+	}
 
-		log.Printf("Found %d logs", len(logs))
-
-		for _, vLog := range logs {
-			deployerAddress := vLog.Address // This captures the deployer address from the log
-
-			token0 := common.BytesToAddress(vLog.Topics[1].Bytes()[12:])
-			token1 := common.BytesToAddress(vLog.Topics[2].Bytes()[12:])
-			factoryAddress := vLog.Address.Hex() // This captures the factory address from the log address
-
-			// Determine the event type based on the first topic
-			switch vLog.Topics[0].Hex() {
-			case pairCreatedTopic: // PoolCreated event
-				// Extract the pool address from the last 20 bytes of the first 32-byte segment of the data field
-				poolAddress := common.BytesToAddress(vLog.Data[32:64]).Hex()
-				log.Printf("New Pool Created: %s, Tokens: %s, %s\n, Deployer: %s, Factory Address: %s", poolAddress, token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
-
-				// Update the call to insertPair to include the deployer and factory address
-				insertPair(poolAddress, token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
-
-			case poolCreatedTopic: // PairCreated event
-				token0 := common.BytesToAddress(vLog.Topics[1].Bytes()[12:])
-				token1 := common.BytesToAddress(vLog.Topics[2].Bytes()[12:])
-				factoryAddress := vLog.Address.Hex() // This captures the factory address from the log address
-
-				// The pair address is in the data field; extract the address from the data field.
-				if len(vLog.Data) < 32 {
-					log.Printf("Insufficient data in log entry: %+v\n", vLog)
-					continue // Skip this log entry
-				}
-				pairAddress := common.BytesToAddress(vLog.Data[12:32]).Hex() // Extracting the pool address from the data field
-
-				log.Printf("New Pair Created: %s, Tokens: %s, %s\n, Deployer: %s, Factory Address: %s", pairAddress, token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
-
-				// Update the call to insertPair to include the deployer and factory address
-				insertPair(pairAddress, token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
-
-			case NewPoolCreatedTopic: // NewPool event
-				tokenX := common.BytesToAddress(vLog.Topics[1].Bytes()[12:])
-				tokenY := common.BytesToAddress(vLog.Topics[2].Bytes()[12:])
-				factoryAddress := vLog.Address.Hex() // This captures the factory address from the log address
-
-				// The pool address is in the data field; extract the address from the data field.
-				if len(vLog.Data) < 32 {
-					log.Printf("Insufficient data in log entry: %+v\n", vLog)
-					continue // Skip this log entry
-				}
-				poolAddress := common.BytesToAddress(vLog.Data[44:64]).Hex() // Extracting the pool address from the data field
-
-				log.Printf("New Pool Created: %s, Tokens: %s, %s\n, Deployer: %s, Factory Address: %s", poolAddress, tokenX.Hex(), tokenY.Hex(), deployerAddress.Hex(), factoryAddress)
-
-				// Update the call to insertPair to include the deployer and factory address
-				insertPair(poolAddress, tokenX.Hex(), tokenY.Hex(), deployerAddress.Hex(), factoryAddress)
-
-			case pairCreatedTopic_2: // PairCreated event for the specified contract
-				token0 := common.BytesToAddress(vLog.Topics[1].Bytes()[12:])
-				token1 := common.BytesToAddress(vLog.Topics[2].Bytes()[12:])
-				factoryAddress := vLog.Address.Hex() // This captures the factory address from the log address
-
-				// The pair address and stable flag are in the data field; check the data field length first
-				if len(vLog.Data) < 64 {
-					log.Printf("Insufficient data in log entry: %+v\n", vLog)
-					continue // Skip this log entry
-				}
-				pairAddress := common.BytesToAddress(vLog.Data[44:64]).Hex()
-
-				log.Printf("New Pair Created: %s, Tokens: %s, %s\n, Deployer: %s, Factory Address: %s", pairAddress, token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
+	startBlock := uint64(0)     // Replace with the actual starting block number
+	endBlock := uint64(4973333) // Replace with the actual ending block number
 
-				// Update the call to insertPair to include the deployer and factory address, and possibly update the function to handle the stable flag
-				insertPair(pairAddress, token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
+	// In follow mode the historical range runs up to the current confirmed head
+	head := func(ctx context.Context) (uint64, error) {
+		return confirmedHead(ctx, client, *headTag, *confirmations)
+	}
+	if *follow {
+		if endBlock, err = head(context.Background()); err != nil {
+			log.Fatalf("Failed to fetch the chain head: %v", err)
+		}
+	}
 
-			case pairCreatedTopic_3: // PoolCreated event for the specified contract
-				token0 := common.BytesToAddress(vLog.Topics[1].Bytes()[12:])
-				token1 := common.BytesToAddress(vLog.Topics[2].Bytes()[12:])
-				factoryAddress := vLog.Address.Hex() // This captures the factory address from the log address
+	// Several workers fetch 10,000 block chunks at once; the chunks are committed in block order,
+	// continuing after the last fully processed one
+	fromBlock := resumeBlock(topicScanner, startBlock)
+	if err := scanConcurrently(context.Background(), client, topicQuery(), fromBlock, endBlock, 10000, *workers, commitTopicRange); err != nil {
+		log.Fatalf("Failed to scan the historical range: %v", err)
+	}
 
-				// The stable flag is in the third topic
-				stable := vLog.Topics[3].Big().Uint64() != 0
+	if *follow {
+		log.Println("Historical range done, following the chain head...")
+		ctx := context.Background()
+		checkpoint := func(lastBlock uint64) {
+			rowWriter.flush(lastBlock, topicScanner)
+		}
+		guard := &reorgGuard{scanner: topicScanner, client: client, tables: []string{"pairs"}, checkpoint: checkpoint}
 
-				// Check the length of the data field to ensure it contains at least one 32-byte parameter
-				if len(vLog.Data) < 32 {
-					log.Printf("Insufficient data in log entry: %+v\n", vLog)
-					continue // Skip this log entry
-				}
-				poolAddress := common.BytesToAddress(vLog.Data[12:32]).Hex() // Extracting the pool address from the data field
+		nextBlock := resumeBlock(topicScanner, endBlock+1)
+		guard.recordIndexed(ctx, nextBlock-1)
 
-				log.Printf("New Pool Created: %s, Tokens: %s, %s\n, Stable: %v, Deployer: %s, Factory Address: %s", poolAddress, token0.Hex(), token1.Hex(), stable, deployerAddress.Hex(), factoryAddress)
+		// Subscriptions deliver logs at the tip, so they are only used without confirmations
+		if *confirmations > 0 || *headTag != "latest" {
+			*wsURL = ""
+		}
 
-				// Update the call to insertPair to include the deployer and factory address, and possibly update the function to handle the stable flag
-				insertPair(poolAddress, token0.Hex(), token1.Hex(), deployerAddress.Hex(), factoryAddress)
-			}
+		pager := newLogPager()
+		f := &follower{
+			wsURL: *wsURL,
+			query: topicQuery(),
+			guard: guard,
+			head:  head,
+			scanRange: func(fromBlock, toBlock uint64) {
+				scanTopicRange(client, pager, fromBlock, toBlock)
+			},
+			handleLog: func(vLog types.Log) {
+				// Subscribed logs are written at once rather than waiting for the next block's checkpoint
+				handleTopicLog(vLog)
+				rowWriter.flush(0)
+			},
+			checkpoint: checkpoint,
 		}
+		f.run(ctx, nextBlock)
 	}
 
 	log.Println("Script completed.")
@@ -0,0 +1,43 @@
+package main
+
+import "github.com/ethereum/go-ethereum/core/types"
+
+// The ERC-20 Transfer event. ERC-721 tokens emit the same topic with the token id indexed, so
+// their logs have four topics and are quarantined rather than stored as token amounts.
+func init() {
+	transfers := registerEventGroup("transfers", "transfers")
+	transfers.register("event Transfer(address indexed from, address indexed to, uint256 value)", tokenTransfer)
+}
+
+// transfersTable writes transfers keyed by their log. Existing rows are left alone rather than
+// updated: the token_balances triggers only count rows that were actually inserted, so a rescanned
+// range does not move any balance twice.
+var transfersTable = &batchTable{
+	name: "transfers",
+	columns: []string{"chain_id", "token_address", "block_number", "block_hash", "block_timestamp", "tx_hash", "log_index",
+		"from_address", "to_address", "value"},
+	conflict:  `ON CONFLICT (chain_id, tx_hash, log_index) DO NOTHING`,
+	key:       []int{0, 5, 6},
+	keepFirst: true,
+}
+
+// tokenTransfer queues a token transfer. Mints come from and burns go to the zero address; the
+// token_balances triggers leave the zero address out.
+func tokenTransfer(vLog types.Log, values map[string]interface{}) error {
+	from, err := anyAddressValue(values, "from")
+	if err != nil {
+		return err
+	}
+	to, err := anyAddressValue(values, "to")
+	if err != nil {
+		return err
+	}
+	value, err := bigValue(values, "value")
+	if err != nil {
+		return err
+	}
+
+	rowWriter.add(transfersTable, chainID, vLog.Address.Hex(), vLog.BlockNumber, vLog.BlockHash.Hex(), logTimestamp(vLog),
+		vLog.TxHash.Hex(), vLog.Index, from.Hex(), to.Hex(), value.String())
+	return nil
+}